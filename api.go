@@ -119,7 +119,7 @@ func api(cli *cli.Context) error {
 	// Initialize controllers
 	var (
 		userController  = controllers.NewUserController(mongoStore, redisStore, signingKey)
-		partyController = controllers.NewPartyController(mongoStore, redisStore)
+		partyController = controllers.NewPartyController(mongoStore, redisStore, SpotifyPlayer.NewConfig(cli))
 	)
 
 	var httpProtocol string
@@ -137,6 +137,13 @@ func api(cli *cli.Context) error {
 	}
 
 	goth.UseProviders(SpotifyPlayer.InitProvider(callbackUrl, cli))
+	SpotifyPlayer.DefaultScheduler.SetBudget(cli.Int("spotify-budget"))
+	SpotifyPlayer.DefaultScheduler.Share(redisStore)
+
+	// Receive party events published by every instance
+	go partyController.Listen()
+	// Carry on with the parties which were running before a restart, once spotify requests can be paced
+	go partyController.Recover()
 
 	gothic.GetProviderName = func(req *http.Request) (string, error) {
 		parts := strings.Split(req.URL.Path, "/")
@@ -238,12 +245,34 @@ func api(cli *cli.Context) error {
 
 	partyGroup.POST("/push", partyController.PushHTTP)
 
+	partyGroup.POST("/vote_skip", partyController.VoteSkipHTTP)
+
+	partyGroup.POST("/vote", partyController.VoteHTTP)
+
+	partyGroup.POST("/remove", partyController.RemoveHTTP)
+
+	partyGroup.POST("/move", partyController.MoveHTTP)
+
 	partyGroup.GET("/player/play", partyController.Play)
 
 	partyGroup.GET("/player/pause", partyController.Pause)
 
 	partyGroup.GET("/player/next", partyController.Next)
 
+	partyGroup.GET("/player/previous", partyController.Previous)
+
+	partyGroup.POST("/player/volume", partyController.Volume)
+
+	partyGroup.POST("/player/seek", partyController.Seek)
+
+	partyGroup.GET("/history", partyController.History)
+
+	partyGroup.GET("/now-playing", partyController.NowPlaying)
+
+	partyGroup.GET("/devices", partyController.Devices)
+
+	partyGroup.POST("/device", partyController.SelectDevice)
+
 	// Handle channel connections
 	m.HandleConnect(func(s *melody.Session) {
 		if channel, ok := s.Get("channel"); ok {
@@ -306,6 +335,18 @@ func api(cli *cli.Context) error {
 			case "queue.push":
 				partyController.PushSocket(s, *msg["item"])
 				break
+			case "queue.remove":
+				partyController.RemoveSocket(s, data)
+				break
+			case "queue.move":
+				partyController.MoveSocket(s, data)
+				break
+			case "queue.vote":
+				partyController.VoteSocket(s, data)
+				break
+			case "queue.vote_skip":
+				partyController.VoteSkipSocket(s)
+				break
 			}
 		} else {
 			errorRes, _ := json.Marshal(gin.H{
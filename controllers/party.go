@@ -2,37 +2,58 @@ package controllers
 
 import (
 	"encoding/json"
-	"errors"
 	"log"
 	"net/url"
+	"strconv"
 
 	"dubclan/api/models"
 	"dubclan/api/party"
 	"dubclan/api/store"
 
-	"github.com/garyburd/redigo/redis"
 	"github.com/gin-gonic/gin"
 	"github.com/olahol/melody"
 	"github.com/urfave/cli"
+	"golang.org/x/oauth2"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 type PartyController struct {
 	baseController
-	partySessions map[string]*party.Session
+	sessions *party.Registry
 }
 
-func NewPartyController(mongo *store.MongoStore, redis *store.RedisStore) PartyController {
+func NewPartyController(mongo *store.MongoStore, redis *store.RedisStore, spotifyConfig *oauth2.Config) PartyController {
 	return PartyController{
 		baseController: newBaseController(mongo, redis),
-		partySessions:  make(map[string]*party.Session),
+		sessions:       party.NewRegistry(mongo, redis, spotifyConfig),
 	}
 }
 
-func (c *PartyController) OnSessionClose(id string) {
-	if _, ok := c.partySessions[id]; ok {
-		delete(c.partySessions, id)
+// Listen delivers messages published by every instance to the party sessions on this one
+func (c *PartyController) Listen() {
+	c.sessions.Listen()
+}
+
+// Recover resumes the parties which were running before the API started
+func (c *PartyController) Recover() {
+	if err := c.sessions.Recover(); err != nil {
+		log.Println("Failed recovering parties", err)
+	}
+}
+
+// Respond to a failure to get a party's session
+func sessionError(context *gin.Context, err error) {
+	if err == mgo.ErrNotFound {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "party_not_found",
+				"msg":  "party not found",
+			},
+		})
+	} else {
+		context.AbortWithError(500, err)
 	}
 }
 
@@ -57,7 +78,23 @@ func (c *PartyController) Get(context *gin.Context) {
 		return
 	}
 
-	context.JSON(200, partyRecord)
+	conn, err := c.Redis.GetConnection()
+	if err != nil {
+		context.AbortWithError(500, err)
+		return
+	}
+	defer conn.Close()
+
+	playback, err := party.LoadPlayback(conn, partyRecord.ID.Hex())
+	if err != nil {
+		context.AbortWithError(500, err)
+		return
+	}
+
+	context.JSON(200, struct {
+		*models.Party
+		Playback *party.PlaybackState `json:"playback"`
+	}{partyRecord, playback})
 }
 
 func (c *PartyController) Create(context *gin.Context, cli *cli.Context) {
@@ -101,9 +138,7 @@ func (c *PartyController) Create(context *gin.Context, cli *cli.Context) {
 		defer conn.Close()
 
 		queue := party.NewQueue()
-		session := party.NewSession(&partyRecord, queue, c.Mongo, c.Redis, c.OnSessionClose)
-
-		c.partySessions[partyRecord.ID.Hex()] = session
+		c.sessions.Start(&partyRecord, queue)
 
 		connectToken, err := party.InitiateConnect(conn, partyRecord, bson.ObjectIdHex(context.GetString("userID")))
 
@@ -170,28 +205,20 @@ func (c *PartyController) Join(context *gin.Context, cli *cli.Context) {
 		return
 	}
 
-	partySession, ok := c.partySessions[partyRecord.ID.Hex()]
-
-	conn, err := c.Redis.GetConnection()
+	partySession, err := c.sessions.Resume(partyRecord.ID.Hex())
 	if err != nil {
 		context.AbortWithError(500, err)
+		return
 	}
-	defer conn.Close()
 
-	if ok {
-		partyRecord = partySession.GetParty()
-	} else if queue, err := party.ResumeQueue(conn, partyRecord.ID.Hex()); err == nil {
-		partySession = party.NewSession(partyRecord, queue, c.Mongo, c.Redis, c.OnSessionClose)
-
-		c.partySessions[partyRecord.ID.Hex()] = partySession
-	} else if err == redis.ErrNil {
-		queue = party.NewQueue()
-		partySession = party.NewSession(partyRecord, queue, c.Mongo, c.Redis, c.OnSessionClose)
+	partyRecord = partySession.GetParty()
 
-		c.partySessions[partyRecord.ID.Hex()] = partySession
-	} else {
+	conn, err := c.Redis.GetConnection()
+	if err != nil {
 		context.AbortWithError(500, err)
+		return
 	}
+	defer conn.Close()
 
 	user, err := models.UserByID(db, bson.ObjectIdHex(context.GetString("userID")))
 
@@ -232,10 +259,17 @@ func (c *PartyController) Join(context *gin.Context, cli *cli.Context) {
 
 	switch err {
 	case nil:
+		playback, err := party.LoadPlayback(conn, partyRecord.ID.Hex())
+		if err != nil {
+			context.AbortWithError(500, err)
+			return
+		}
+
 		res := gin.H{
-			"url":   connectUrl + "/party/connect/" + url.PathEscape(connectToken),
-			"party": partyRecord,
-			"queue": partySession.GetQueue(),
+			"url":      connectUrl + "/party/connect/" + url.PathEscape(connectToken),
+			"party":    partyRecord,
+			"queue":    partySession.GetQueue(),
+			"playback": playback,
 		}
 
 		context.JSON(200, res)
@@ -259,7 +293,8 @@ func (c *PartyController) Leave(context *gin.Context) {
 	session, db := c.Mongo.DB()
 	defer session.Close()
 
-	partySession, sessionExists := c.partySessions[partyId]
+	partySession, sessionErr := c.sessions.Resume(partyId)
+	sessionExists := sessionErr == nil
 
 	var partyRecord *models.Party
 	var err error
@@ -416,10 +451,10 @@ func (c *PartyController) HandleConnect(s *melody.Session) {
 	partyId := s.MustGet("party_id").(string)
 
 	// Notify others this attendee has become active
-	if session, ok := c.partySessions[partyId]; ok {
+	if session, err := c.sessions.Resume(partyId); err == nil {
 		session.ClientConnected(s)
 	} else {
-		log.Printf("No party session exists for (%s), something's fucky", partyId)
+		log.Println("Failed getting party session", partyId, err)
 		return
 	}
 
@@ -434,11 +469,11 @@ func (c *PartyController) HandleConnect(s *melody.Session) {
 func (c *PartyController) HandleDisconnect(s *melody.Session) {
 	partyId, _ := s.Get("party_id")
 
-	if session, ok := c.partySessions[partyId.(string)]; ok {
+	if session, ok := c.sessions.Get(partyId.(string)); ok {
 		// Cleanup session from the party map
 		session.ClientDisconnected(s)
 	} else {
-		log.Printf("No party session exists for (%s), something's fucky", partyId)
+		log.Printf("No party session exists for (%s)", partyId)
 	}
 }
 
@@ -467,14 +502,22 @@ func (c *PartyController) PushSocket(s *melody.Session, rawItem json.RawMessage)
 
 	partyId, _ := s.Get("party_id")
 
-	if session, ok := c.partySessions[partyId.(string)]; ok {
-		// Cleanup session from the party map
+	if session, err := c.sessions.Resume(partyId.(string)); err == nil {
 		if err := session.Push(item); err != nil {
-			log.Println("Failed pushing item to queue", err)
+			if res := pushError(err); res != nil {
+				errorRes, _ := json.Marshal(gin.H{
+					"type":  "error",
+					"error": res,
+				})
+
+				s.Write([]byte(errorRes))
+			} else {
+				log.Println("Failed pushing item to queue", err)
+			}
 			return
 		}
 	} else {
-		log.Printf("No party session exists for (%s), something's fucky", partyId)
+		log.Println("Failed getting party session", partyId, err)
 	}
 }
 
@@ -501,21 +544,261 @@ func (c *PartyController) PushHTTP(context *gin.Context) {
 
 	partyId := context.Query("id")
 
-	if session, ok := c.partySessions[partyId]; ok {
-		// Cleanup session from the party map
-		if err := session.Push(item); err != nil {
+	if session, err := c.sessions.Resume(partyId); err == nil {
+		if err := session.Push(item); err == party.PushRateLimited {
+			context.JSON(429, gin.H{
+				"type":  "error",
+				"error": pushError(err),
+			})
+		} else if err == party.NotPermitted {
+			context.JSON(403, gin.H{
+				"type":  "error",
+				"error": pushError(err),
+			})
+		} else if res := pushError(err); res != nil {
+			context.JSON(400, gin.H{
+				"type":  "error",
+				"error": res,
+			})
+		} else if err != nil {
 			context.AbortWithError(500, err)
+		} else {
+			context.JSON(200, gin.H{})
 		}
-		context.JSON(200, gin.H{})
 	} else {
-		context.AbortWithError(500, errors.New("No party session exists for (%s), something's fucky"+partyId))
+		sessionError(context, err)
 	}
 }
 
-func (c *PartyController) Play(context *gin.Context) {
+func pushError(err error) gin.H {
+	switch err {
+	case party.QueueLimitReached:
+		return gin.H{
+			"code": "queue_limit_reached",
+			"msg":  "The queue is full",
+		}
+	case party.AttendeeLimitReached:
+		return gin.H{
+			"code": "attendee_limit_reached",
+			"msg":  "You have too many items waiting in the queue",
+		}
+	case party.PushRateLimited:
+		return gin.H{
+			"code": "rate_limited",
+			"msg":  "You're adding items too quickly, try again in a minute",
+		}
+	case party.DuplicatePending:
+		return gin.H{
+			"code": "duplicate_pending",
+			"msg":  "This is already in the queue",
+		}
+	case party.DuplicateRecent:
+		return gin.H{
+			"code": "duplicate_recent",
+			"msg":  "This was played recently",
+		}
+	case party.NotPermitted:
+		return gin.H{
+			"code": "not_permitted",
+			"msg":  "Only the host can add local tracks",
+		}
+	}
+
+	return nil
+}
+
+type voteRequest struct {
+	Item string `json:"item" binding:"required"`
+	Vote int    `json:"vote"`
+}
+
+func itemError(err error) gin.H {
+	switch err {
+	case party.ItemNotFound:
+		return gin.H{
+			"code": "item_not_found",
+			"msg":  "Item is not in the queue",
+		}
+	case party.ItemPlaying:
+		return gin.H{
+			"code": "item_playing",
+			"msg":  "Item is already handed to the player",
+		}
+	case party.NotPermitted:
+		return gin.H{
+			"code": "not_permitted",
+			"msg":  "Not permitted to change this item",
+		}
+	}
+
+	return nil
+}
+
+func (c *PartyController) VoteSocket(s *melody.Session, rawVote json.RawMessage) {
+	var vote voteRequest
+
+	if err := json.Unmarshal(rawVote, &vote); err != nil || !bson.IsObjectIdHex(vote.Item) {
+		errorRes, _ := json.Marshal(gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+
+		s.Write([]byte(errorRes))
+		return
+	}
+
+	userId := s.MustGet("user_id").(string)
+	partyId, _ := s.Get("party_id")
+
+	if session, err := c.sessions.Resume(partyId.(string)); err == nil {
+		if err := session.Vote(bson.ObjectIdHex(userId), bson.ObjectIdHex(vote.Item), vote.Vote); err != nil {
+			if res := itemError(err); res != nil {
+				errorRes, _ := json.Marshal(gin.H{
+					"type":  "error",
+					"error": res,
+				})
+
+				s.Write([]byte(errorRes))
+			} else {
+				log.Println("Failed voting on item", err)
+			}
+		}
+	} else {
+		log.Println("Failed getting party session", partyId, err)
+	}
+}
+
+func (c *PartyController) VoteHTTP(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
 	partyId := bson.ObjectIdHex(context.Query("id"))
 
-	if !partyId.Valid() {
+	var vote voteRequest
+
+	if err := context.BindJSON(&vote); err != nil || !bson.IsObjectIdHex(vote.Item) {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+		return
+	}
+
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if !session.GetParty().IsMember(userId) {
+			context.JSON(403, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "not_attendee",
+					"msg":  "Not an attendee of this party",
+				},
+			})
+			return
+		}
+
+		if err := session.Vote(userId, bson.ObjectIdHex(vote.Item), vote.Vote); err != nil {
+			if res := itemError(err); res != nil {
+				context.JSON(400, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+type itemRequest struct {
+	Item     string `json:"item" binding:"required"`
+	Position int    `json:"position"`
+}
+
+func (c *PartyController) RemoveSocket(s *melody.Session, rawRequest json.RawMessage) {
+	c.itemSocket(s, rawRequest, func(session *party.Session, userId bson.ObjectId, req itemRequest) error {
+		return session.Remove(userId, bson.ObjectIdHex(req.Item))
+	})
+}
+
+func (c *PartyController) MoveSocket(s *melody.Session, rawRequest json.RawMessage) {
+	c.itemSocket(s, rawRequest, func(session *party.Session, userId bson.ObjectId, req itemRequest) error {
+		return session.Move(userId, bson.ObjectIdHex(req.Item), req.Position)
+	})
+}
+
+func (c *PartyController) RemoveHTTP(context *gin.Context) {
+	c.itemHTTP(context, func(session *party.Session, userId bson.ObjectId, req itemRequest) error {
+		return session.Remove(userId, bson.ObjectIdHex(req.Item))
+	})
+}
+
+func (c *PartyController) MoveHTTP(context *gin.Context) {
+	c.itemHTTP(context, func(session *party.Session, userId bson.ObjectId, req itemRequest) error {
+		return session.Move(userId, bson.ObjectIdHex(req.Item), req.Position)
+	})
+}
+
+// Handle a socket request operating on a queued item
+func (c *PartyController) itemSocket(s *melody.Session, rawRequest json.RawMessage, apply func(*party.Session, bson.ObjectId, itemRequest) error) {
+	var req itemRequest
+
+	if err := json.Unmarshal(rawRequest, &req); err != nil || !bson.IsObjectIdHex(req.Item) {
+		errorRes, _ := json.Marshal(gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+
+		s.Write([]byte(errorRes))
+		return
+	}
+
+	userId := s.MustGet("user_id").(string)
+	partyId, _ := s.Get("party_id")
+
+	if session, err := c.sessions.Resume(partyId.(string)); err == nil {
+		if err := apply(session, bson.ObjectIdHex(userId), req); err != nil {
+			if res := itemError(err); res != nil {
+				errorRes, _ := json.Marshal(gin.H{
+					"type":  "error",
+					"error": res,
+				})
+
+				s.Write([]byte(errorRes))
+			} else {
+				log.Println("Failed changing queue item", err)
+			}
+		}
+	} else {
+		log.Println("Failed getting party session", partyId, err)
+	}
+}
+
+// Handle a HTTP request operating on a queued item
+func (c *PartyController) itemHTTP(context *gin.Context, apply func(*party.Session, bson.ObjectId, itemRequest) error) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
 		context.AbortWithStatusJSON(400, gin.H{
 			"type": "error",
 			"error": gin.H{
@@ -523,21 +806,169 @@ func (c *PartyController) Play(context *gin.Context) {
 				"msg":  "Invalid party",
 			},
 		})
+		return
 	}
 
-	if session, ok := c.partySessions[partyId.Hex()]; ok {
-		if err := session.Play(); err != nil {
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	var req itemRequest
+
+	if err := context.BindJSON(&req); err != nil || !bson.IsObjectIdHex(req.Item) {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+		return
+	}
+
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := apply(session, userId, req); err == party.NotPermitted {
+			context.JSON(403, gin.H{
+				"type":  "error",
+				"error": itemError(err),
+			})
+		} else if res := itemError(err); res != nil {
+			context.JSON(400, gin.H{
+				"type":  "error",
+				"error": res,
+			})
+		} else if err != nil {
 			context.AbortWithError(500, err)
 		} else {
 			context.JSON(200, gin.H{})
 		}
+	} else {
+		sessionError(context, err)
 	}
 }
 
-func (c *PartyController) Pause(context *gin.Context) {
+func voteSkipError(err error) gin.H {
+	switch err {
+	case party.VoteSkipDisabled:
+		return gin.H{
+			"code": "vote_skip_disabled",
+			"msg":  "Vote skipping is disabled for this party",
+		}
+	case party.AlreadyVoted:
+		return gin.H{
+			"code": "already_voted",
+			"msg":  "Already voted to skip this item",
+		}
+	case party.EmptyQueue:
+		return gin.H{
+			"code": "empty_queue",
+			"msg":  "Nothing to skip",
+		}
+	}
+
+	return nil
+}
+
+func (c *PartyController) VoteSkipSocket(s *melody.Session) {
+	userId := s.MustGet("user_id").(string)
+	partyId, _ := s.Get("party_id")
+
+	if session, err := c.sessions.Resume(partyId.(string)); err == nil {
+		if err := session.VoteSkip(userId); err != nil {
+			if res := voteSkipError(err); res != nil {
+				errorRes, _ := json.Marshal(gin.H{
+					"type":  "error",
+					"error": res,
+				})
+
+				s.Write([]byte(errorRes))
+			} else {
+				log.Println("Failed voting to skip", err)
+			}
+		}
+	} else {
+		log.Println("Failed getting party session", partyId, err)
+	}
+}
+
+func (c *PartyController) VoteSkipHTTP(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if !session.GetParty().IsMember(userId) {
+			context.JSON(403, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "not_attendee",
+					"msg":  "Not an attendee of this party",
+				},
+			})
+			return
+		}
+
+		if err := session.VoteSkip(userId.Hex()); err != nil {
+			if res := voteSkipError(err); res != nil {
+				context.JSON(400, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) Play(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
 	partyId := bson.ObjectIdHex(context.Query("id"))
 
-	if !partyId.Valid() {
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := session.Play(); err != nil {
+			if status, res := controlError(err); res != nil {
+				context.JSON(status, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) Pause(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
 		context.AbortWithStatusJSON(400, gin.H{
 			"type": "error",
 			"error": gin.H{
@@ -545,21 +976,63 @@ func (c *PartyController) Pause(context *gin.Context) {
 				"msg":  "Invalid party",
 			},
 		})
+		return
 	}
 
-	if session, ok := c.partySessions[partyId.Hex()]; ok {
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
 		if err := session.Pause(); err != nil {
-			context.AbortWithError(500, err)
+			if status, res := controlError(err); res != nil {
+				context.JSON(status, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
 		} else {
 			context.JSON(200, gin.H{})
 		}
+	} else {
+		sessionError(context, err)
 	}
 }
 
 func (c *PartyController) Next(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
 	partyId := bson.ObjectIdHex(context.Query("id"))
 
-	if !partyId.Valid() {
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := session.Next(); err != nil {
+			if status, res := controlError(err); res != nil {
+				context.JSON(status, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) Previous(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
 		context.AbortWithStatusJSON(400, gin.H{
 			"type": "error",
 			"error": gin.H{
@@ -567,13 +1040,333 @@ func (c *PartyController) Next(context *gin.Context) {
 				"msg":  "Invalid party",
 			},
 		})
+		return
 	}
 
-	if session, ok := c.partySessions[partyId.Hex()]; ok {
-		if err := session.Next(); err != nil {
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := session.Previous(); err == party.EmptyHistory {
+			context.JSON(400, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "empty_history",
+					"msg":  "No previous item to play",
+				},
+			})
+		} else if err != nil {
 			context.AbortWithError(500, err)
 		} else {
 			context.JSON(200, gin.H{})
 		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func controlError(err error) (int, gin.H) {
+	switch err {
+	case party.NotPermitted:
+		return 403, gin.H{
+			"code": "not_permitted",
+			"msg":  "Only the host can control the player",
+		}
+	case party.InvalidVolume:
+		return 400, gin.H{
+			"code": "invalid_volume",
+			"msg":  "Volume must be between 0 and 100",
+		}
+	case party.InvalidPosition:
+		return 400, gin.H{
+			"code": "invalid_position",
+			"msg":  "Position must not be negative",
+		}
+	case party.EmptyQueue:
+		return 400, gin.H{
+			"code": "empty_queue",
+			"msg":  "Nothing is playing",
+		}
+	case party.Interrupted:
+		return 400, gin.H{
+			"code": "interrupted",
+			"msg":  "Playback is interrupted",
+		}
 	}
+
+	return 0, nil
+}
+
+// Apply a host's player control with a value taken from the request's field
+func (c *PartyController) controlHTTP(context *gin.Context, field string, apply func(*party.Session, bson.ObjectId, int) error) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	var req map[string]int
+
+	if err := context.BindJSON(&req); err != nil {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+		return
+	}
+
+	value, ok := req[field]
+	if !ok {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Missing " + field + " field",
+			},
+		})
+		return
+	}
+
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := apply(session, userId, value); err != nil {
+			if status, res := controlError(err); res != nil {
+				context.JSON(status, gin.H{
+					"type":  "error",
+					"error": res,
+				})
+			} else {
+				context.AbortWithError(500, err)
+			}
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) Volume(context *gin.Context) {
+	c.controlHTTP(context, "volume", func(session *party.Session, userId bson.ObjectId, volume int) error {
+		return session.SetVolume(userId, volume)
+	})
+}
+
+func (c *PartyController) Seek(context *gin.Context) {
+	c.controlHTTP(context, "position", func(session *party.Session, userId bson.ObjectId, position int) error {
+		return session.Seek(userId, position)
+	})
+}
+
+func (c *PartyController) NowPlaying(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if nowPlaying, err := session.NowPlaying(); err != nil {
+			context.AbortWithError(500, err)
+		} else {
+			context.JSON(200, nowPlaying)
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) Devices(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if session.GetParty().HostID != userId {
+			context.JSON(403, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "not_permitted",
+					"msg":  "Only the host can choose the party's device",
+				},
+			})
+			return
+		}
+
+		if devices, err := session.Devices(); err == party.NoSpotifyAccount {
+			context.JSON(400, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "no_spotify_account",
+					"msg":  "Host has no spotify account",
+				},
+			})
+		} else if err != nil {
+			context.AbortWithError(500, err)
+		} else {
+			context.JSON(200, gin.H{
+				"devices": devices,
+				"device":  session.GetParty().Settings.Device,
+			})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) SelectDevice(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	var req struct {
+		Device string `json:"device"`
+	}
+
+	if err := context.BindJSON(&req); err != nil {
+		context.JSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_json",
+				"msg":  "Invalid JSON message",
+			},
+		})
+		return
+	}
+
+	userId := bson.ObjectIdHex(context.MustGet("userID").(string))
+
+	if session, err := c.sessions.Resume(partyId.Hex()); err == nil {
+		if err := session.SelectDevice(userId, req.Device); err == party.NotPermitted {
+			context.JSON(403, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"code": "not_permitted",
+					"msg":  "Only the host can choose the party's device",
+				},
+			})
+		} else if err != nil {
+			context.AbortWithError(500, err)
+		} else {
+			context.JSON(200, gin.H{})
+		}
+	} else {
+		sessionError(context, err)
+	}
+}
+
+func (c *PartyController) History(context *gin.Context) {
+	if !bson.IsObjectIdHex(context.Query("id")) {
+		context.AbortWithStatusJSON(400, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"code": "invalid_party",
+				"msg":  "Invalid party",
+			},
+		})
+		return
+	}
+
+	partyId := bson.ObjectIdHex(context.Query("id"))
+
+	offset, err := strconv.Atoi(context.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.Atoi(context.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 20
+	}
+
+	conn, err := c.Redis.GetConnection()
+	if err != nil {
+		context.AbortWithError(500, err)
+		return
+	}
+	defer conn.Close()
+
+	items, total, err := party.History(conn, partyId.Hex(), offset, limit)
+	if err != nil {
+		context.AbortWithError(500, err)
+		return
+	}
+
+	session, db := c.Mongo.DB()
+	defer session.Close()
+
+	ids := []bson.ObjectId{}
+	for _, item := range items {
+		if addedBy := item.GetAddedBy(); addedBy.Valid() {
+			ids = append(ids, addedBy)
+		}
+	}
+
+	users, err := models.UsersByIDs(db, ids)
+	if err != nil {
+		context.AbortWithError(500, err)
+		return
+	}
+
+	usersById := make(map[bson.ObjectId]models.User, len(users))
+	for _, user := range users {
+		usersById[user.ID] = user
+	}
+
+	entries := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		entry := gin.H{
+			"item": item,
+		}
+
+		if user, ok := usersById[item.GetAddedBy()]; ok {
+			entry["added_by"] = user
+		}
+
+		entries = append(entries, entry)
+	}
+
+	context.JSON(200, gin.H{
+		"items":  entries,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	})
 }
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"sync"
+)
+
+// Bus hands the events published on it to every subscriber, in the order they were published.
+// Publishing never blocks so a player can publish from the session's loop, which subscribes to it.
+type Bus struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+// Publish queues the event for every subscriber
+func (b *Bus) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscriptions {
+		s.push(event)
+	}
+}
+
+// Subscribe to the events published from now on, the subscription's channel is closed once the
+// bus is closed
+func (b *Bus) Subscribe() *Subscription {
+	s := &Subscription{
+		events: make(chan Event),
+		wake:   make(chan bool, 1),
+		done:   make(chan bool),
+	}
+	s.C = s.events
+
+	go s.forward()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		close(s.done)
+	} else {
+		b.subscriptions[s] = true
+	}
+
+	return s
+}
+
+// Unsubscribe stops handing events to s, those it didn't receive yet are dropped
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscriptions[s] {
+		delete(b.subscriptions, s)
+		close(s.done)
+	}
+}
+
+// Close unsubscribes everyone, events published afterwards go nowhere
+func (b *Bus) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscriptions {
+		close(s.done)
+	}
+
+	b.subscriptions = make(map[*Subscription]bool)
+	b.closed = true
+}
+
+// Subscription receives the events published on a bus on C
+type Subscription struct {
+	C <-chan Event
+
+	events  chan Event
+	mutex   sync.Mutex
+	pending []Event
+	wake    chan bool // Signalled when events are pending
+	done    chan bool // Closed when unsubscribed
+}
+
+func (s *Subscription) push(event Event) {
+	s.mutex.Lock()
+	s.pending = append(s.pending, event)
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// Hand pending events to the subscriber one at a time until unsubscribed
+func (s *Subscription) forward() {
+	defer close(s.events)
+
+	for {
+		s.mutex.Lock()
+		pending := s.pending
+		s.pending = nil
+		s.mutex.Unlock()
+
+		for _, event := range pending {
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, s *Subscription) Event {
+	select {
+	case event := <-s.C:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+
+	return nil
+}
+
+func TestBusDeliversInOrder(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+
+	first := bus.Subscribe()
+	second := bus.Subscribe()
+
+	// Nobody's receiving yet, publishing doesn't wait for them
+	for i := 0; i < 100; i++ {
+		bus.Publish(Progress{Progress: i})
+	}
+	bus.Publish(TrackFinished{})
+
+	for _, s := range []*Subscription{first, second} {
+		for i := 0; i < 100; i++ {
+			if event := receive(t, s); event != (Progress{Progress: i}) {
+				t.Fatal("expected progress", i, "got", event)
+			}
+		}
+
+		if event := receive(t, s); event != (TrackFinished{}) {
+			t.Fatal("expected the track to finish, got", event)
+		}
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	defer bus.Close()
+
+	s := bus.Subscribe()
+	bus.Unsubscribe(s)
+	bus.Publish(Paused{})
+
+	if _, ok := <-s.C; ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+
+	// Twice is harmless
+	bus.Unsubscribe(s)
+}
+
+func TestBusClose(t *testing.T) {
+	bus := NewBus()
+
+	s := bus.Subscribe()
+	bus.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+
+	if _, ok := <-bus.Subscribe().C; ok {
+		t.Fatal("expected subscribing to a closed bus to be closed straight away")
+	}
+
+	bus.Publish(Interrupted{})
+}
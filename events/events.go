@@ -0,0 +1,57 @@
+// Package events carries what happens to a party's playback from its player to the party's session,
+// and anything else which subscribes to the party's bus
+package events
+
+import (
+	"golang.org/x/oauth2"
+)
+
+// Event is one of the events below
+type Event interface {
+	event()
+}
+
+// TrackStarted is published when the current item starts or resumes playing
+type TrackStarted struct{}
+
+// TrackFinished is published when the current item ended, the player dropped it from its items
+type TrackFinished struct{}
+
+// Paused is published when playback of the current item is paused
+type Paused struct{}
+
+// Interrupted is published when something other than the player's items plays on the host's device, or nothing does
+type Interrupted struct{}
+
+// Progress is how far into the current item playback is
+type Progress struct {
+	Progress int // Milliseconds into the item
+	Duration int // Of the item, in milliseconds
+}
+
+// TokenRefreshed is published when the host's token for a provider expired and was refreshed
+type TokenRefreshed struct {
+	Provider string
+	Token    *oauth2.Token
+}
+
+// ReauthRequired is published when the host's token for a provider can't be refreshed, they have to sign in again
+type ReauthRequired struct {
+	Provider string
+	Reason   string
+}
+
+// HostMessage is published by players which have the host's client do the playing, the session sends
+// the message to the host's client as is
+type HostMessage struct {
+	Message []byte
+}
+
+func (TrackStarted) event()   {}
+func (TrackFinished) event()  {}
+func (Paused) event()         {}
+func (Interrupted) event()    {}
+func (Progress) event()       {}
+func (TokenRefreshed) event() {}
+func (ReauthRequired) event() {}
+func (HostMessage) event()    {}
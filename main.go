@@ -51,6 +51,12 @@ var flags = []cli.Flag{
 		EnvVar: "SPOTIFY_SECRET",
 		Name:   "spotify-secret",
 	},
+	cli.IntFlag{
+		EnvVar: "SPOTIFY_REQUEST_BUDGET",
+		Name:   "spotify-budget",
+		Usage:  "requests per second to spotify across every instance",
+		Value:  10,
+	},
 }
 
 func main() {
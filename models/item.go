@@ -11,31 +11,110 @@ import (
 
 type Item interface {
 	Added(by bson.ObjectId)
+	GetID() (bson.ObjectId)
+	GetAddedBy() (bson.ObjectId)
+	GetAddedAt() (time.Time)
+	GetScore() (int)
+	GetVotes() (map[string]int)
+	Vote(by bson.ObjectId, vote int) (bool)
 	UpdateState(state ItemState)
+	Merge(other Item)
+	GetState() (ItemState)
 	GetType() (string)
 	GetPlayerType() (string)
+	// Identifies the media the item plays, items playing the same media share a URI
+	GetURI() (string)
 	Play() (bool)
 	Pause() (bool)
 	Done() (bool)
 }
 
 type ItemState struct {
-	Progress  int  `json:"progress"`
-	Playing   bool `json:"playing"`
-	Completed bool `json:"completed"`
+	Progress    int       `json:"progress"`
+	Playing     bool      `json:"playing"`
+	Completed   bool      `json:"completed"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
 }
 
 type BaseItem struct {
 	Item                  `json:"-"`
-	Type    string        `json:"type" bson:"type"`
-	AddedBy bson.ObjectId `json:"added_by" bson:"added_by,omitempty"`
-	AddedAt time.Time     `json:"added_at" bson:"added_at"`
-	State   ItemState     `json:"state" bson:"state"`
+	ID      bson.ObjectId  `json:"id" bson:"id,omitempty"`
+	Type    string         `json:"type" bson:"type"`
+	AddedBy bson.ObjectId  `json:"added_by" bson:"added_by,omitempty"`
+	AddedAt time.Time      `json:"added_at" bson:"added_at"`
+	State   ItemState      `json:"state" bson:"state"`
+	Score   int            `json:"score" bson:"score"`
+	Votes   map[string]int `json:"votes,omitempty" bson:"votes,omitempty"`
 }
 
 func (i *BaseItem) Added(by bson.ObjectId) {
+	i.ID = bson.NewObjectId()
 	i.AddedAt = time.Now()
 	i.AddedBy = by
+	i.Score = 0
+	i.Votes = nil
+}
+
+func (i *BaseItem) GetID() bson.ObjectId {
+	return i.ID
+}
+
+func (i *BaseItem) GetAddedBy() bson.ObjectId {
+	return i.AddedBy
+}
+
+func (i *BaseItem) GetAddedAt() time.Time {
+	return i.AddedAt
+}
+
+func (i *BaseItem) GetScore() int {
+	return i.Score
+}
+
+func (i *BaseItem) GetVotes() map[string]int {
+	return i.Votes
+}
+
+// Record an up (1) or down (-1) vote, 0 clears the vote. Returns whether the score changed
+func (i *BaseItem) Vote(by bson.ObjectId, vote int) bool {
+	if vote > 1 {
+		vote = 1
+	} else if vote < -1 {
+		vote = -1
+	}
+
+	previous := i.Votes[by.Hex()]
+	if previous == vote {
+		return false
+	}
+
+	if i.Votes == nil {
+		i.Votes = make(map[string]int)
+	}
+
+	if vote == 0 {
+		delete(i.Votes, by.Hex())
+	} else {
+		i.Votes[by.Hex()] = vote
+	}
+
+	i.Score += vote - previous
+	return true
+}
+
+func (i *BaseItem) UpdateState(state ItemState) {
+	i.State = state
+}
+
+// Take the state, score and votes of another copy of the item, nothing else about an item changes
+func (i *BaseItem) Merge(other Item) {
+	i.State = other.GetState()
+	i.Score = other.GetScore()
+	i.Votes = other.GetVotes()
+}
+
+func (i *BaseItem) GetState() ItemState {
+	return i.State
 }
 
 func (i *BaseItem) GetType() string {
@@ -66,6 +145,7 @@ func (i *BaseItem) Done() bool {
 	}
 
 	i.State.Completed = true
+	i.State.CompletedAt = time.Now()
 	return true
 }
 
@@ -86,6 +166,9 @@ func (u *ItemUnpacker) UnmarshalJSON(b []byte) (error) {
 		case "spotify_track":
 			item = &SpotifyTrack{}
 			break
+		case "local_track":
+			item = &LocalTrack{}
+			break
 		default:
 			return errors.New("invalid item type")
 		}
@@ -94,6 +177,16 @@ func (u *ItemUnpacker) UnmarshalJSON(b []byte) (error) {
 			return err
 		}
 
+		if track, ok := item.(*LocalTrack); ok {
+			if track.URL == "" || track.Duration <= 0 {
+				return errors.New("local track missing url or duration")
+			}
+
+			if track.Duration > MaxLocalTrackDuration {
+				return errors.New("local track is too long")
+			}
+		}
+
 		u.Result = item
 		return nil
 	}
@@ -113,3 +206,33 @@ func (i *SpotifyTrack) GetType() string {
 func (i *SpotifyTrack) GetPlayerType() (string) {
 	return "spotify"
 }
+
+func (i *SpotifyTrack) GetURI() string {
+	return string(i.URI)
+}
+
+// The longest local track the host's client is told to play, in milliseconds. The player's clock
+// trusts the duration it's given, so a longer one would hold up the queue.
+const MaxLocalTrackDuration = 2 * 60 * 60 * 1000
+
+// A track from a venue's own library, the host's client plays it from a path or an HTTP URL
+type LocalTrack struct {
+	BaseItem
+	URL      string `json:"url" bson:"url"`
+	Title    string `json:"title" bson:"title"`
+	Artist   string `json:"artist,omitempty" bson:"artist,omitempty"`
+	Album    string `json:"album,omitempty" bson:"album,omitempty"`
+	Duration int    `json:"duration" bson:"duration"` // Milliseconds
+}
+
+func (i *LocalTrack) GetType() string {
+	return i.Type
+}
+
+func (i *LocalTrack) GetPlayerType() (string) {
+	return "local"
+}
+
+func (i *LocalTrack) GetURI() string {
+	return i.URL
+}
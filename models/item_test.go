@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestUnpackLocalTrackDuration(t *testing.T) {
+	for duration, valid := range map[int]bool{
+		0:                         false,
+		-1:                        false,
+		3 * 60 * 1000:             true,
+		MaxLocalTrackDuration:     true,
+		MaxLocalTrackDuration + 1: false,
+	} {
+		u := &ItemUnpacker{}
+		raw := `{"type":"local_track","url":"/music/a.mp3","title":"a","duration":` + strconv.Itoa(duration) + `}`
+
+		if err := json.Unmarshal([]byte(raw), u); (err == nil) != valid {
+			t.Error("unexpected result unpacking a local track lasting", duration, err)
+		}
+	}
+}
@@ -23,8 +23,31 @@ type Party struct {
 
 type Settings struct {
 	Timeout time.Duration `json:"timeout" bson:"timeout"`
+	// Percentage of connected attendees needed to vote skip the current item, 0 disables vote skipping
+	SkipThreshold int `json:"skip_threshold" bson:"skip_threshold"`
+	// Interleave pending items round-robin between the attendees who added them
+	FairShare bool `json:"fair_share" bson:"fair_share"`
+	// Limits on attendees' pushes, 0 is unlimited. The host is exempt
+	MaxPendingPerAttendee int `json:"max_pending_per_attendee" bson:"max_pending_per_attendee"`
+	MaxPushesPerMinute    int `json:"max_pushes_per_minute" bson:"max_pushes_per_minute"`
+	MaxQueueLength        int `json:"max_queue_length" bson:"max_queue_length"`
+	// Whether the same media can be queued again, see the Duplicates policies
+	DuplicatePolicy string `json:"duplicate_policy" bson:"duplicate_policy"`
+	// Minutes a finished item counts as recent under the DuplicatesRecent policy
+	DuplicateWindow int `json:"duplicate_window" bson:"duplicate_window"`
+	// ID of the host's spotify device playback is sent to, spotify picks one when empty
+	Device string `json:"device" bson:"device"`
 }
 
+const (
+	// Allow queueing anything
+	DuplicatesAllow = "allow"
+	// Reject items which are already in the queue
+	DuplicatesPending = "pending"
+	// Reject items which are in the queue or finished within the duplicate window
+	DuplicatesRecent = "recent"
+)
+
 type Attendee struct {
 	UserId   bson.ObjectId `json:"-" bson:"user_id"`
 	User     User          `json:"user" bson:"user,omitempty"`
@@ -51,11 +74,11 @@ func NewAttendee(user User) Attendee {
 	}
 }
 
-func PartyByCode(db *mgo.Database, code string) (*Party, error) {
-	var party Party
-
-	err := db.C(PartyCollection).Pipe([]bson.M{
-		{"$match": bson.M{"join_code": code}},
+// The stages loading a party matching match along with its host and attendees. The party's
+// fields are grouped back together after the attendees are looked up, every field has to be kept.
+func partyPipeline(match bson.M) []bson.M {
+	return []bson.M{
+		{"$match": match},
 		{
 			"$lookup": bson.M{
 				"localField":   "host_id",
@@ -83,6 +106,7 @@ func PartyByCode(db *mgo.Database, code string) (*Party, error) {
 				"created_at": bson.M{"$first": "$created_at"},
 				"host_id":    bson.M{"$first": "$host_id"},
 				"host":       bson.M{"$first": "$host"},
+				"settings":   bson.M{"$first": "$settings"},
 				"attendees":  bson.M{"$push": "$attendees"},
 			},
 		},
@@ -93,68 +117,70 @@ func PartyByCode(db *mgo.Database, code string) (*Party, error) {
 				"created_at": 1,
 				"host_id":    1,
 				"host":       1,
+				"settings":   1,
 				"attendees": bson.M{
 					"$cond": []interface{}{bson.M{"$ne": []interface{}{"$attendees.user", []interface{}{}}}, "$attendees", []interface{}{}},
 				},
 			},
 		},
-	}).One(&party)
+	}
+}
+
+func PartyByCode(db *mgo.Database, code string) (*Party, error) {
+	var party Party
+
+	err := db.C(PartyCollection).Pipe(partyPipeline(bson.M{"join_code": code})).One(&party)
 
 	return &party, err
 }
 
+// PartyIDs lists the ids of every party
+func PartyIDs(db *mgo.Database) ([]bson.ObjectId, error) {
+	var parties []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+
+	if err := db.C(PartyCollection).Find(nil).Select(bson.M{"_id": 1}).All(&parties); err != nil {
+		return nil, err
+	}
+
+	ids := make([]bson.ObjectId, 0, len(parties))
+	for _, party := range parties {
+		ids = append(ids, party.ID)
+	}
+
+	return ids, nil
+}
+
 func PartyByID(db *mgo.Database, id bson.ObjectId) (*Party, error) {
 	var party Party
 
-	err := db.C(PartyCollection).Pipe([]bson.M{
-		{"$match": bson.M{"_id": id}},
-		{
-			"$lookup": bson.M{
-				"localField":   "host_id",
-				"from":         UserCollection,
-				"foreignField": "_id",
-				"as":           "host",
-			},
-		},
-		{"$unwind": "$host"},
-		{"$unwind": bson.M{"path": "$attendees", "preserveNullAndEmptyArrays": true}},
-		{
-			"$lookup": bson.M{
-				"localField":   "attendees.user_id",
-				"from":         UserCollection,
-				"foreignField": "_id",
-				"as":           "attendees.user",
-			},
-		},
-		{"$unwind": bson.M{"path": "$attendees.user", "preserveNullAndEmptyArrays": true}},
-		{
-			"$group": bson.M{
-				"_id":        "$_id",
-				"name":       bson.M{"$first": "$name"},
-				"join_code":  bson.M{"$first": "$join_code"},
-				"created_at": bson.M{"$first": "$created_at"},
-				"host_id":    bson.M{"$first": "$host_id"},
-				"host":       bson.M{"$first": "$host"},
-				"attendees":  bson.M{"$push": "$attendees"},
-			},
-		},
-		{
-			"$project": bson.M{
-				"name":       1,
-				"join_code":  1,
-				"created_at": 1,
-				"host_id":    1,
-				"host":       1,
-				"attendees": bson.M{
-					"$cond": []interface{}{bson.M{"$ne": []interface{}{"$attendees.user", []interface{}{}}}, "$attendees", []interface{}{}},
-				},
-			},
-		},
-	}).One(&party)
+	err := db.C(PartyCollection).Pipe(partyPipeline(bson.M{"_id": id})).One(&party)
 
 	return &party, err
 }
 
+func (p *Party) IsMember(userId bson.ObjectId) bool {
+	if p.HostID == userId {
+		return true
+	}
+
+	for _, attendee := range p.Attendees {
+		if attendee.UserId == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetPartyDevice stores the spotify device the party's playback is sent to
+func SetPartyDevice(db *mgo.Database, id bson.ObjectId, device string) error {
+	return db.C(PartyCollection).UpdateId(id, bson.M{
+		"$set": bson.M{"settings.device": device},
+	})
+}
+
 func (p *Party) Insert(db *mgo.Database) error {
 	err := db.C(PartyCollection).Insert(p)
 
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Every field of a party survives grouping it back together after its attendees are looked up
+func TestPartyPipelineKeepsFields(t *testing.T) {
+	var group, project bson.M
+	for _, stage := range partyPipeline(bson.M{}) {
+		if g, ok := stage["$group"]; ok {
+			group = g.(bson.M)
+		}
+		if p, ok := stage["$project"]; ok {
+			project = p.(bson.M)
+		}
+	}
+
+	fields := reflect.TypeOf(Party{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := strings.Split(fields.Field(i).Tag.Get("bson"), ",")[0]
+		if name == "_id" {
+			continue
+		}
+
+		if _, ok := group[name]; !ok {
+			t.Error("expected the party's", name, "to be grouped")
+		}
+
+		if _, ok := project[name]; !ok {
+			t.Error("expected the party's", name, "to be projected")
+		}
+	}
+}
+
+// Runs against the mongo server at MONGO_TEST_URL, a throwaway database is created and dropped
+func TestPartyByIDLoadsSettings(t *testing.T) {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL isn't set")
+	}
+
+	session, err := mgo.DialWithTimeout(url, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	db := session.DB("test_" + bson.NewObjectId().Hex())
+	defer db.DropDatabase()
+
+	host := User{ID: bson.NewObjectId(), Username: "host"}
+	if err := db.C(UserCollection).Insert(host); err != nil {
+		t.Fatal(err)
+	}
+
+	party := NewParty(host.ID, "party", "code", Settings{SkipThreshold: 60, FairShare: true})
+	if err := db.C(PartyCollection).Insert(party); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, load := range map[string]func() (*Party, error){
+		"id":   func() (*Party, error) { return PartyByID(db, party.ID) },
+		"code": func() (*Party, error) { return PartyByCode(db, "code") },
+	} {
+		loaded, err := load()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Settings.SkipThreshold != 60 || !loaded.Settings.FairShare {
+			t.Error("expected the party loaded by", name, "to keep its settings, got", loaded.Settings)
+		}
+	}
+}
@@ -70,6 +70,15 @@ func UserByID(db *mgo.Database, id bson.ObjectId) (*User, error) {
 	return &user, err
 }
 
+func UsersByIDs(db *mgo.Database, ids []bson.ObjectId) ([]User, error) {
+	var users []User
+	err := db.C(UserCollection).Find(bson.M{
+		"_id": bson.M{"$in": ids},
+	}).All(&users)
+
+	return users, err
+}
+
 func UpdateUserByIdentity(db *mgo.Database, identity Identity) (*User, error) {
 	var user User
 
@@ -124,6 +133,20 @@ func UpdateUserIdentity(db *mgo.Database, id bson.ObjectId, identity Identity) (
 	return err
 }
 
+// UpdateIdentityToken stores a refreshed access token for the user's identity with the provider
+func UpdateIdentityToken(db *mgo.Database, id bson.ObjectId, provider string, token *oauth2.Token) error {
+	return db.C(UserCollection).Update(bson.M{
+		"_id":                 id,
+		"identities.provider": provider,
+	}, bson.M{
+		"$set": bson.M{
+			"identities.$.access_token":  token.AccessToken,
+			"identities.$.refresh_token": token.RefreshToken,
+			"identities.$.expires_at":    token.Expiry,
+		},
+	})
+}
+
 func (u *User) NewToken(host string, signingKey []byte) (string, error) {
 	claims := APIClaims{
 		StandardClaims: jwt.StandardClaims{
@@ -0,0 +1,94 @@
+package party
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"dubclan/api/store"
+
+	"github.com/garyburd/redigo/redis"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// Events to deliver to a party's clients
+	EventMessage = "event"
+	// Commands for the instance that owns a party's players
+	CommandMessage = "command"
+)
+
+// Message is published to every API instance through a party's redis channel
+type Message struct {
+	Origin string          `json:"origin"`
+	Kind   string          `json:"kind"`
+	To     string          `json:"to,omitempty"`
+	Except string          `json:"except,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Broker fans party messages out between API instances using redis pub/sub
+type Broker struct {
+	redis    *store.RedisStore
+	instance string
+}
+
+func NewBroker(redisStore *store.RedisStore) *Broker {
+	return &Broker{
+		redis:    redisStore,
+		instance: bson.NewObjectId().Hex(),
+	}
+}
+
+// Instance identifies this API instance
+func (b *Broker) Instance() string {
+	return b.instance
+}
+
+func (b *Broker) Publish(partyId string, msg Message) error {
+	msg.Origin = b.instance
+
+	serialized, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	conn, err := b.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", EventsPrefix+partyId, serialized)
+	return err
+}
+
+// Listen delivers the messages published for every party until the subscription fails
+func (b *Broker) Listen(deliver func(partyId string, msg Message)) error {
+	conn, err := b.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	if err := psc.PSubscribe(EventsPrefix + "*"); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.PMessage:
+			var msg Message
+			if err := json.Unmarshal(v.Data, &msg); err != nil {
+				log.Println("Invalid party message", err)
+				continue
+			}
+
+			deliver(strings.TrimPrefix(v.Channel, EventsPrefix), msg)
+		case error:
+			return v
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package party
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// How long an instance owns a party without renewing its lease
+	leaseTTL = 15 * time.Second
+	// How often the lease is renewed, or taken over when its owner is gone
+	leaseRenewal = 5 * time.Second
+)
+
+// IsOwner returns whether this instance owns the party's players and timeout
+func (s *Session) IsOwner() bool {
+	s.ownerMutex.Lock()
+	defer s.ownerMutex.Unlock()
+
+	return s.owner
+}
+
+func (s *Session) renewLease() {
+	owned := false
+
+	if conn, err := s.redis.GetConnection(); err == nil {
+		owned, err = redis.Bool(renewLeaseScript.Do(conn, OwnerPrefix+s.id, s.broker.Instance(), int64(leaseTTL/time.Millisecond)))
+		conn.Close()
+
+		if err != nil {
+			log.Println("Failed renewing party lease", err)
+		}
+	} else {
+		log.Println("Failed renewing party lease", err)
+	}
+
+	s.setOwner(owned)
+}
+
+func (s *Session) releaseLease(conn redis.Conn) {
+	if _, err := releaseLeaseScript.Do(conn, OwnerPrefix+s.id, s.broker.Instance()); err != nil {
+		log.Println("Failed releasing party lease", err)
+	}
+}
+
+func (s *Session) setOwner(owned bool) {
+	s.ownerMutex.Lock()
+	changed := s.owner != owned
+	s.owner = owned
+	s.ownerMutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if owned {
+		log.Println("Took ownership of party", s.id)
+		s.setupTimeout()
+		s.reconcile()
+	} else {
+		// Another instance takes over playback, leave it playing
+		log.Println("Lost ownership of party", s.id)
+		s.stopTimeout()
+		s.resetPlayers()
+	}
+}
+
+// Dispose of the players, they're recreated when needed
+func (s *Session) resetPlayers() {
+	s.CurrentPlayer = nil
+
+	for key, p := range s.players {
+		p.Stop()
+		delete(s.players, key)
+	}
+
+	s.playerChanged()
+}
+
+// Number of items at the head of the queue which keep their place, the item the player is on.
+// The items after it are kept in sync with the queue by syncPlayer.
+func (s *Session) playingItems() int {
+	if s.IsOwner() {
+		if s.CurrentPlayer == nil || !s.CurrentPlayer.HasItems() {
+			return 0
+		}
+
+		return 1
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return 0
+	}
+	defer conn.Close()
+
+	playing, _ := redis.Int(conn.Do("GET", PlayingPrefix+s.id))
+	return playing
+}
+
+// Share the number of items handed to the player with the other instances
+func (s *Session) playerChanged() {
+	if !s.IsOwner() {
+		return
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		log.Println("Failed sharing player items", err)
+		return
+	}
+	defer conn.Close()
+
+	playing := 0
+	if s.CurrentPlayer != nil && s.CurrentPlayer.HasItems() {
+		playing = 1
+	}
+
+	if _, err := conn.Do("SET", PlayingPrefix+s.id, playing); err != nil {
+		log.Println("Failed sharing player items", err)
+	}
+}
+
+// Hand the items queued after the one playing to the player, so it carries on without waiting for the session
+func (s *Session) syncPlayer() {
+	if !s.IsOwner() || s.CurrentPlayer == nil || !s.CurrentPlayer.HasItems() {
+		return
+	}
+
+	current := s.CurrentPlayer.GetItems()
+	items := s.queue.GetNextPlayableList()
+
+	if len(items) == 0 || items[0].GetID() != current[0].GetID() {
+		// The session hasn't caught up with the player yet
+		return
+	}
+
+	if len(items) == len(current) {
+		synced := true
+		for i := range items {
+			if items[i].GetID() != current[i].GetID() {
+				synced = false
+				break
+			}
+		}
+
+		if synced {
+			return
+		}
+	}
+
+	if err := s.CurrentPlayer.Sync(items); err != nil {
+		log.Println("Failed syncing player", err)
+	}
+}
+
+// Send a player command to the instance that owns the party
+func (s *Session) forward(command string) error {
+	return s.forwardValue(command, 0)
+}
+
+// Send a player command taking a value to the instance that owns the party
+func (s *Session) forwardValue(command string, value int) error {
+	data, err := json.Marshal(gin.H{
+		"command": command,
+		"value":   value,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.broker.Publish(s.id, Message{
+		Kind: CommandMessage,
+		Data: data,
+	})
+}
+
+func (s *Session) runCommand(data json.RawMessage) {
+	var command struct {
+		Command string `json:"command"`
+		Value   int    `json:"value"`
+	}
+
+	if err := json.Unmarshal(data, &command); err != nil {
+		log.Println("Invalid party command", err)
+		return
+	}
+
+	var err error
+	switch command.Command {
+	case "play":
+		err = s.play()
+	case "pause":
+		err = s.pause()
+	case "next":
+		err = s.next()
+	case "previous":
+		err = s.previous()
+	case "transfer_host":
+		if err = s.reloadParty(); err == nil {
+			s.transferPlayers()
+		}
+	case "volume":
+		err = s.changeVolume(command.Value)
+	case "seek":
+		err = s.seekTo(command.Value)
+	case "select_device":
+		if err = s.reloadParty(); err == nil {
+			err = s.deviceChanged()
+		}
+	default:
+		log.Println("Unknown party command", command.Command)
+	}
+
+	if err != nil {
+		log.Println("Failed running party command", command.Command, err)
+	}
+}
+
+// Handle a message published for the party by any instance
+func (s *Session) receive(msg Message) {
+	switch msg.Kind {
+	case EventMessage:
+		if msg.Origin != s.broker.Instance() {
+			var event struct {
+				Type string `json:"type"`
+			}
+
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				log.Println("Invalid party event", err)
+				return
+			}
+
+			// Catch up with changes made by another instance
+			switch event.Type {
+			case "queue.change":
+				if err := s.refreshQueue(); err != nil {
+					log.Println("Failed refreshing queue", err)
+				}
+			case "attendees.change":
+				if err := s.reloadParty(); err != nil {
+					log.Println("Failed reloading party", err)
+				}
+			}
+		}
+
+		s.deliver(msg)
+	case CommandMessage:
+		if s.IsOwner() {
+			s.runCommand(msg.Data)
+		}
+	}
+}
+
+func (s *Session) refreshQueue() error {
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return s.queue.Refresh(conn, s.id)
+}
+
+func (s *Session) reloadParty() error {
+	partyRecord, err := s.records.Party(bson.ObjectIdHex(s.id))
+	if err != nil {
+		return err
+	}
+
+	s.partyMutex.Lock()
+	s.party = partyRecord
+	s.partyMutex.Unlock()
+
+	return nil
+}
+
+// Write a message to the clients connected to this instance
+func (s *Session) deliver(msg Message) {
+	for id, client := range s.clients {
+		if (msg.To != "" && id != msg.To) || id == msg.Except {
+			continue
+		}
+
+		if writeErr := client.Write(msg.Data); writeErr != nil {
+			if writeErr.Error() == "session is closed" {
+				delete(s.clients, id)
+			} else {
+				log.Println(writeErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package party
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dubclan/api/player"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	InvalidVolume = errors.New("volume must be between 0 and 100")
+
+	InvalidPosition = errors.New("position must not be negative")
+)
+
+type VolumeCommand struct {
+	UserID  bson.ObjectId
+	Percent int
+}
+
+func (c VolumeCommand) apply(s *Session) error {
+	return s.setVolume(c.UserID, c.Percent)
+}
+
+type SeekCommand struct {
+	UserID   bson.ObjectId
+	Position int
+}
+
+func (c SeekCommand) apply(s *Session) error {
+	return s.seek(c.UserID, c.Position)
+}
+
+// SetVolume changes the volume of the host's player, only the host can change it
+func (s *Session) SetVolume(userId bson.ObjectId, percent int) error {
+	return s.Do(VolumeCommand{UserID: userId, Percent: percent})
+}
+
+// Seek moves playback of the current item to position milliseconds, only the host can seek
+func (s *Session) Seek(userId bson.ObjectId, position int) error {
+	return s.Do(SeekCommand{UserID: userId, Position: position})
+}
+
+func (s *Session) setVolume(userId bson.ObjectId, percent int) error {
+	if userId != s.record().HostID {
+		return NotPermitted
+	}
+
+	if percent < 0 || percent > 100 {
+		return InvalidVolume
+	}
+
+	return s.changeVolume(percent)
+}
+
+func (s *Session) seek(userId bson.ObjectId, position int) error {
+	if userId != s.record().HostID {
+		return NotPermitted
+	}
+
+	if position < 0 {
+		return InvalidPosition
+	}
+
+	return s.seekTo(position)
+}
+
+// The player the host is controlling, if it has something to control
+func (s *Session) controlledPlayer() (player.Player, error) {
+	if s.CurrentPlayer == nil || !s.CurrentPlayer.HasItems() {
+		return nil, EmptyQueue
+	}
+
+	if s.CurrentPlayer.GetState() == player.INTERRUPTED {
+		return nil, Interrupted
+	}
+
+	return s.CurrentPlayer, nil
+}
+
+func (s *Session) changeVolume(percent int) error {
+	if !s.IsOwner() {
+		return s.forwardValue("volume", percent)
+	}
+
+	p, err := s.controlledPlayer()
+	if err != nil {
+		return err
+	}
+
+	if err := p.SetVolume(percent); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"type":   "player.volume",
+		"volume": percent,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
+}
+
+func (s *Session) seekTo(position int) error {
+	if !s.IsOwner() {
+		return s.forwardValue("seek", position)
+	}
+
+	p, err := s.controlledPlayer()
+	if err != nil {
+		return err
+	}
+
+	if err := p.Seek(position); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"type":     "player.seek",
+		"item":     p.GetItems()[0].GetID(),
+		"progress": position,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
+}
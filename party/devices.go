@@ -0,0 +1,82 @@
+package party
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dubclan/api/player/spotify"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var NoSpotifyAccount = errors.New("host has no spotify account")
+
+type SelectDeviceCommand struct {
+	UserID bson.ObjectId
+	Device string
+}
+
+func (c SelectDeviceCommand) apply(s *Session) error {
+	return s.selectDevice(c.UserID, c.Device)
+}
+
+// Devices lists the host's spotify devices the party can be played on
+func (s *Session) Devices() ([]spotify.Device, error) {
+	token := s.record().Host.GetIdentityToken("spotify")
+	if token == nil {
+		return nil, NoSpotifyAccount
+	}
+
+	return spotify.Devices(s.bus, s.spotifyConfig, token)
+}
+
+// SelectDevice sends the party's playback to one of the host's spotify devices, only the host can choose it
+func (s *Session) SelectDevice(userId bson.ObjectId, device string) error {
+	return s.Do(SelectDeviceCommand{UserID: userId, Device: device})
+}
+
+func (s *Session) selectDevice(userId bson.ObjectId, device string) error {
+	if userId != s.record().HostID {
+		return NotPermitted
+	}
+
+	// Kept with the party's settings so it's used again when the party is resumed
+	if err := s.records.SetPartyDevice(bson.ObjectIdHex(s.id), device); err != nil {
+		return err
+	}
+
+	if err := s.reloadParty(); err != nil {
+		return err
+	}
+
+	if s.IsOwner() {
+		if err := s.deviceChanged(); err != nil {
+			return err
+		}
+	} else if err := s.forward("select_device"); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"type":   "player.device",
+		"device": device,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
+}
+
+// Move playback to the device in the party's settings
+func (s *Session) deviceChanged() error {
+	if p, ok := s.players["spotify"].(*spotify.Player); ok {
+		return p.SetDevice(s.record().Settings.Device)
+	}
+
+	return nil
+}
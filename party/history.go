@@ -0,0 +1,42 @@
+package party
+
+import (
+	"encoding/json"
+
+	"dubclan/api/models"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Maximum number of finished items kept in a party's history
+const MaxHistory = 200
+
+// History returns a page of a party's finished items, most recent first, along with the total history length
+func History(conn redis.Conn, id string, offset, limit int) ([]models.Item, int, error) {
+	total, err := redis.Int(conn.Do("LLEN", HistoryPrefix+id))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := []models.Item{}
+
+	if offset >= total || limit <= 0 {
+		return items, total, nil
+	}
+
+	list, err := redis.Strings(conn.Do("LRANGE", HistoryPrefix+id, offset, offset+limit-1))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, raw := range list {
+		u := &models.ItemUnpacker{}
+		if err := json.Unmarshal([]byte(raw), u); err != nil {
+			return nil, 0, err
+		}
+
+		items = append(items, u.Result)
+	}
+
+	return items, total, nil
+}
@@ -0,0 +1,121 @@
+package party
+
+import (
+	"time"
+
+	"dubclan/api/models"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Window the push rate limit is counted over, in seconds
+const pushRateWindow = 60
+
+func pushRateKey(partyId, userId string) string {
+	return PushRatePrefix + partyId + ":" + userId
+}
+
+// Check a push against the party's queue limits, the host is exempt
+func (s *Session) checkPushLimits(conn redis.Conn, item models.Item) error {
+	party := s.record()
+
+	addedBy := item.GetAddedBy()
+	if addedBy == party.HostID {
+		return nil
+	}
+
+	settings := party.Settings
+	items := s.queue.Snapshot()
+
+	if settings.MaxQueueLength > 0 && len(items) >= settings.MaxQueueLength {
+		return QueueLimitReached
+	}
+
+	if settings.MaxPendingPerAttendee > 0 {
+		// The item that's playing is no longer pending
+		start := 0
+		if s.playingItems() > 0 {
+			start = 1
+		}
+
+		pending := 0
+		for i := start; i < len(items); i++ {
+			if items[i].GetAddedBy() == addedBy {
+				pending++
+			}
+		}
+
+		if pending >= settings.MaxPendingPerAttendee {
+			return AttendeeLimitReached
+		}
+	}
+
+	if settings.MaxPushesPerMinute > 0 {
+		pushes, err := redis.Int(conn.Do("GET", pushRateKey(s.id, addedBy.Hex())))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+
+		if pushes >= settings.MaxPushesPerMinute {
+			return PushRateLimited
+		}
+	}
+
+	return nil
+}
+
+// Count a push towards the attendee's rate limit
+func (s *Session) recordPush(conn redis.Conn, item models.Item) error {
+	if party := s.record(); party.Settings.MaxPushesPerMinute <= 0 || item.GetAddedBy() == party.HostID {
+		return nil
+	}
+
+	key := pushRateKey(s.id, item.GetAddedBy().Hex())
+
+	// The window starts with the first push, INCR keeps the expiry
+	conn.Send("MULTI")
+	conn.Send("SET", key, 0, "EX", pushRateWindow, "NX")
+	conn.Send("INCR", key)
+	_, err := conn.Do("EXEC")
+
+	return err
+}
+
+// Check a push against the party's duplicate policy
+func (s *Session) checkDuplicate(conn redis.Conn, item models.Item) error {
+	settings := s.record().Settings
+	policy := settings.DuplicatePolicy
+	if policy != models.DuplicatesPending && policy != models.DuplicatesRecent {
+		return nil
+	}
+
+	uri := item.GetURI()
+
+	for _, queued := range s.queue.Snapshot() {
+		if queued.GetURI() == uri {
+			return DuplicatePending
+		}
+	}
+
+	if policy == models.DuplicatesRecent && settings.DuplicateWindow > 0 {
+		history, _, err := History(conn, s.id, 0, MaxHistory)
+		if err != nil {
+			return err
+		}
+
+		cutoff := time.Now().Add(-time.Duration(settings.DuplicateWindow) * time.Minute)
+
+		// History is ordered most recent first
+		for _, played := range history {
+			if finished := played.GetState().CompletedAt; !finished.IsZero() && finished.Before(cutoff) {
+				break
+			}
+
+			if played.GetURI() == uri {
+				return DuplicateRecent
+			}
+		}
+	}
+
+	return nil
+}
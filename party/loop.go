@@ -0,0 +1,450 @@
+package party
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+
+	"github.com/gin-gonic/gin"
+	"github.com/olahol/melody"
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// Commands waiting for the session's loop before senders block
+	commandBuffer = 64
+	// Least time between progress events
+	progressInterval = 5 * time.Second
+)
+
+// Command is a change to a party session. Commands are applied one at a time by the session's loop,
+// which is the only goroutine changing the session's queue, players and clients.
+type Command interface {
+	apply(s *Session) error
+}
+
+type request struct {
+	command Command
+	result  chan error
+}
+
+type PushCommand struct {
+	Item models.Item
+}
+
+func (c PushCommand) apply(s *Session) error {
+	return s.push(c.Item)
+}
+
+type VoteCommand struct {
+	UserID bson.ObjectId
+	ItemID bson.ObjectId
+	Vote   int
+}
+
+func (c VoteCommand) apply(s *Session) error {
+	return s.vote(c.UserID, c.ItemID, c.Vote)
+}
+
+type VoteSkipCommand struct {
+	UserID string
+}
+
+func (c VoteSkipCommand) apply(s *Session) error {
+	return s.voteSkip(c.UserID)
+}
+
+type RemoveCommand struct {
+	UserID bson.ObjectId
+	ItemID bson.ObjectId
+}
+
+func (c RemoveCommand) apply(s *Session) error {
+	return s.remove(c.UserID, c.ItemID)
+}
+
+type MoveCommand struct {
+	UserID   bson.ObjectId
+	ItemID   bson.ObjectId
+	Position int
+}
+
+func (c MoveCommand) apply(s *Session) error {
+	return s.move(c.UserID, c.ItemID, c.Position)
+}
+
+type PlayCommand struct{}
+
+func (c PlayCommand) apply(s *Session) error {
+	return s.play()
+}
+
+type PauseCommand struct{}
+
+func (c PauseCommand) apply(s *Session) error {
+	return s.pause()
+}
+
+type NextCommand struct{}
+
+func (c NextCommand) apply(s *Session) error {
+	return s.next()
+}
+
+type PreviousCommand struct{}
+
+func (c PreviousCommand) apply(s *Session) error {
+	return s.previous()
+}
+
+type ConnectCommand struct {
+	Client *melody.Session
+}
+
+func (c ConnectCommand) apply(s *Session) error {
+	s.clientConnected(c.Client)
+	return nil
+}
+
+type DisconnectCommand struct {
+	Client *melody.Session
+}
+
+func (c DisconnectCommand) apply(s *Session) error {
+	s.clientDisconnected(c.Client)
+	return nil
+}
+
+type AttendeesChangedCommand struct{}
+
+func (c AttendeesChangedCommand) apply(s *Session) error {
+	return s.attendeesChanged()
+}
+
+type TransferHostCommand struct {
+	To models.User
+}
+
+func (c TransferHostCommand) apply(s *Session) error {
+	return s.transferHost(c.To)
+}
+
+type receiveCommand struct {
+	msg Message
+}
+
+func (c receiveCommand) apply(s *Session) error {
+	s.receive(c.msg)
+	return nil
+}
+
+// Do sends a command to the session's loop and waits for its result
+func (s *Session) Do(command Command) error {
+	result := make(chan error, 1)
+
+	select {
+	case s.commands <- request{command, result}:
+	case <-s.stop:
+		return SessionClosed
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-s.stop:
+		// The command may have finished just before the session stopped
+		select {
+		case err := <-result:
+			return err
+		default:
+			return SessionClosed
+		}
+	}
+}
+
+// Send a command to the session's loop without waiting for it to be applied
+func (s *Session) post(command Command) {
+	select {
+	case s.commands <- request{command, nil}:
+	case <-s.stop:
+	}
+}
+
+// The session's loop, it applies commands, handles player events and holds the party's lease until the session stops
+func (s *Session) run(stop <-chan bool) {
+	defer s.waiter.Done()
+
+	playerEvents := s.bus.Subscribe().C
+
+	// Try to take ownership straight away so a new party can be played on this instance
+	s.renewLease()
+
+	lease := time.NewTicker(leaseRenewal)
+	defer lease.Stop()
+
+	for {
+		select {
+		case req := <-s.commands:
+			err := req.command.apply(s)
+			if req.result != nil {
+				req.result <- err
+			}
+		case event, ok := <-playerEvents:
+			if ok {
+				s.playerEvent(event)
+			} else {
+				playerEvents = nil
+			}
+		case <-lease.C:
+			s.renewLease()
+		case <-stop:
+			return
+		}
+
+		s.syncPlayer()
+		s.savePlayback()
+	}
+}
+
+// Handle an event published by one of the party's players
+func (s *Session) playerEvent(event events.Event) {
+	switch e := event.(type) {
+	case events.TrackFinished:
+		s.trackFinished()
+	case events.TrackStarted:
+		s.playing()
+	case events.Paused:
+		s.paused()
+	case events.Interrupted:
+		s.interrupted()
+	case events.Progress:
+		s.progressed(e.Progress, e.Duration)
+	case events.TokenRefreshed:
+		s.tokenRefreshed(e.Provider, e.Token)
+	case events.ReauthRequired:
+		s.reauthRequired(e.Provider, e.Reason)
+	case events.HostMessage:
+		s.writeToClient(s.record().HostID.Hex(), e.Message)
+	}
+}
+
+func (s *Session) trackFinished() {
+	log.Println("CHANGE")
+	conn, err := s.redis.GetConnection()
+
+	if err != nil {
+		log.Println("Failed popping finished item", err)
+		return
+	}
+
+	if s.queue.ChangeItem(0, models.Item.Done) != nil {
+		if _, err := s.queue.Pop(conn, s.id); err != nil {
+			log.Println("Failed popping finished item", err)
+		}
+	}
+	conn.Close()
+
+	if s.CurrentPlayer != nil && s.CurrentPlayer.HasItems() && s.CurrentPlayer.GetState() == player.PLAYING {
+		// The player carried on with the next item by itself
+		s.queue.ChangeItem(0, models.Item.Play)
+		s.UpdateHead()
+	} else if s.play() != nil {
+		s.setupTimeout()
+	} else {
+		s.UpdateHead()
+	}
+	s.playerChanged()
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err == nil {
+		s.writeToClients(event)
+	}
+}
+
+func (s *Session) interrupted() {
+	s.setupTimeout()
+
+	log.Println("INTERRUPT")
+	event, _ := json.Marshal(map[string]interface{}{
+		"type": "player.interrupted",
+	})
+
+	s.writeToClients(event)
+}
+
+func (s *Session) playing() {
+	if s.queue.Len() == 0 {
+		return
+	}
+
+	s.queue.ChangeItem(0, models.Item.Play)
+	log.Println("PLAY")
+
+	s.UpdateHead()
+
+	event, _ := json.Marshal(map[string]interface{}{
+		"type": "player.play",
+	})
+
+	s.writeToClients(event)
+}
+
+func (s *Session) paused() {
+	if s.queue.Len() == 0 {
+		return
+	}
+
+	s.queue.ChangeItem(0, models.Item.Pause)
+	log.Println("PAUSED")
+
+	s.UpdateHead()
+
+	event, _ := json.Marshal(map[string]interface{}{
+		"type": "player.pause",
+	})
+
+	s.writeToClients(event)
+}
+
+// Keep how far into the head of the queue playback is, clients are told at most once per progressInterval
+func (s *Session) progressed(progress, duration int) {
+	s.queue.ChangeItem(0, func(item models.Item) bool {
+		state := item.GetState()
+		state.Progress = progress
+		item.UpdateState(state)
+		return true
+	})
+
+	if time.Since(s.lastProgress) < progressInterval {
+		return
+	}
+
+	head := s.queue.Head()
+	if head == nil {
+		return
+	}
+
+	s.lastProgress = time.Now()
+
+	event, _ := json.Marshal(gin.H{
+		"type":     "player.progress",
+		"item":     head.GetID(),
+		"progress": progress,
+		"duration": duration,
+	})
+
+	s.writeToClients(event)
+}
+
+// Keep the host's refreshed token so players created later, by any instance, don't have to refresh it
+func (s *Session) tokenRefreshed(provider string, token *oauth2.Token) {
+	if err := s.records.UpdateIdentityToken(s.record().HostID, provider, token); err != nil {
+		log.Println("Failed saving refreshed token", err)
+		return
+	}
+
+	if err := s.reloadParty(); err != nil {
+		log.Println("Failed reloading party", err)
+	}
+}
+
+// The host's token couldn't be refreshed, playback can't be controlled until they sign in again
+func (s *Session) reauthRequired(provider string, reason string) {
+	log.Println("Host has to sign in with", provider, "again", reason)
+
+	s.reauth = true
+	s.resetPlayers()
+	s.setupTimeout()
+
+	event, _ := json.Marshal(gin.H{
+		"type":     "host.reauth_required",
+		"provider": provider,
+	})
+
+	s.writeToClient(s.record().HostID.Hex(), event)
+}
+
+func (s *Session) ClientConnected(client *melody.Session) {
+	if err := s.Do(ConnectCommand{Client: client}); err != nil {
+		log.Println("Failed connecting client", err)
+	}
+}
+
+func (s *Session) ClientDisconnected(client *melody.Session) {
+	if err := s.Do(DisconnectCommand{Client: client}); err != nil {
+		log.Println("Failed disconnecting client", err)
+	}
+}
+
+func (s *Session) Push(item models.Item) error {
+	return s.Do(PushCommand{Item: item})
+}
+
+func (s *Session) Vote(userId bson.ObjectId, itemId bson.ObjectId, vote int) error {
+	return s.Do(VoteCommand{UserID: userId, ItemID: itemId, Vote: vote})
+}
+
+func (s *Session) VoteSkip(userId string) error {
+	return s.Do(VoteSkipCommand{UserID: userId})
+}
+
+// Remove an item from the queue. Hosts can remove any item, attendees only their own.
+// Removing the item that's playing skips it.
+func (s *Session) Remove(userId bson.ObjectId, itemId bson.ObjectId) error {
+	return s.Do(RemoveCommand{UserID: userId, ItemID: itemId})
+}
+
+// Move an item to a new position in the queue, only the host can reorder the queue
+func (s *Session) Move(userId bson.ObjectId, itemId bson.ObjectId, position int) error {
+	return s.Do(MoveCommand{UserID: userId, ItemID: itemId, Position: position})
+}
+
+func (s *Session) Play() error {
+	return s.Do(PlayCommand{})
+}
+
+func (s *Session) Pause() error {
+	return s.Do(PauseCommand{})
+}
+
+func (s *Session) Next() error {
+	return s.Do(NextCommand{})
+}
+
+func (s *Session) Previous() error {
+	return s.Do(PreviousCommand{})
+}
+
+func (s *Session) AttendeesChanged() error {
+	return s.Do(AttendeesChangedCommand{})
+}
+
+func (s *Session) TransferHost(to models.User) error {
+	return s.Do(TransferHostCommand{To: to})
+}
+
+// Receive handles a message published for the party by any instance
+func (s *Session) Receive(msg Message) {
+	if msg.Kind == EventMessage && msg.Origin != s.broker.Instance() {
+		var event struct {
+			Type string `json:"type"`
+		}
+
+		// Closing stops the loop so it can't be done from within it
+		if err := json.Unmarshal(msg.Data, &event); err == nil && event.Type == "party.close" {
+			s.teardown()
+			return
+		}
+	}
+
+	s.post(receiveCommand{msg})
+}
@@ -0,0 +1,165 @@
+package party
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"dubclan/api/models"
+	"dubclan/api/player"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Version of the persisted playback state, bumped whenever a field is added or changes meaning
+const PlaybackSchema = 1
+
+var UnsupportedPlayback = errors.New("playback state was saved by a newer version")
+
+// PlaybackState is the party's playback as last seen by the instance running it, kept in a redis hash
+// so any instance can resume it and clients can render what's playing when they join
+type PlaybackState struct {
+	Schema int `json:"schema" redis:"schema"`
+	// Type of the player playing the party's items, empty when nothing's handed to a player
+	Player string `json:"player" redis:"player"`
+	// Item at the head of the queue the player is on
+	Item string `json:"item,omitempty" redis:"item"`
+	// Milliseconds into the item
+	Progress    int  `json:"progress" redis:"progress"`
+	Playing     bool `json:"playing" redis:"playing"`
+	Interrupted bool `json:"interrupted" redis:"interrupted"`
+	// When the inactive party is closed in unix milliseconds, 0 when it's active
+	Deadline  int64 `json:"deadline,omitempty" redis:"deadline"`
+	UpdatedAt int64 `json:"updated_at" redis:"updated_at"`
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// LoadPlayback gets a party's persisted playback state
+func LoadPlayback(conn redis.Conn, id string) (*PlaybackState, error) {
+	values, err := redis.Values(conn.Do("HGETALL", PlaybackPrefix+id))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &PlaybackState{}
+	if err := redis.ScanStruct(values, state); err != nil {
+		return nil, err
+	}
+
+	if state.Schema > PlaybackSchema {
+		return nil, UnsupportedPlayback
+	} else if state.Schema < PlaybackSchema {
+		// Nothing or an older schema was saved, only the deadline carries over
+		state = &PlaybackState{Deadline: state.Deadline}
+	}
+
+	state.Schema = PlaybackSchema
+
+	return state, nil
+}
+
+// NowPlaying is the item at the head of the queue and how far into it playback is
+type NowPlaying struct {
+	Item        models.Item `json:"item"`
+	Progress    int         `json:"progress"`
+	Playing     bool        `json:"playing"`
+	Interrupted bool        `json:"interrupted"`
+}
+
+func (s *Session) NowPlaying() (*NowPlaying, error) {
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	playback, err := LoadPlayback(conn, s.id)
+	if err != nil {
+		return nil, err
+	}
+
+	head := s.queue.Head()
+	if head == nil || playback.Item != head.GetID().Hex() {
+		// Nothing's been handed to a player
+		return &NowPlaying{}, nil
+	}
+
+	nowPlaying := &NowPlaying{
+		Item:        head,
+		Progress:    playback.Progress,
+		Playing:     playback.Playing,
+		Interrupted: playback.Interrupted,
+	}
+
+	// Account for the time since the state was saved
+	if playback.Playing && playback.UpdatedAt > 0 {
+		nowPlaying.Progress += int(unixMillis(time.Now()) - playback.UpdatedAt)
+	}
+
+	return nowPlaying, nil
+}
+
+// Playback state of the session's player
+func (s *Session) playback() *PlaybackState {
+	state := &PlaybackState{
+		Schema:    PlaybackSchema,
+		UpdatedAt: unixMillis(time.Now()),
+	}
+
+	if s.CurrentPlayer == nil {
+		return state
+	}
+
+	for playerType, p := range s.players {
+		if p == s.CurrentPlayer {
+			state.Player = playerType
+		}
+	}
+
+	if items := s.CurrentPlayer.GetItems(); len(items) > 0 {
+		state.Item = items[0].GetID().Hex()
+		state.Progress = s.CurrentPlayer.GetProgress()
+	}
+
+	switch s.CurrentPlayer.GetState() {
+	case player.PLAYING:
+		state.Playing = true
+	case player.INTERRUPTED:
+		state.Interrupted = true
+	}
+
+	return state
+}
+
+// Persist the session's playback state, the deadline is left to the timeout
+func (s *Session) savePlayback() {
+	if !s.IsOwner() {
+		return
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		log.Println("Failed saving playback state", err)
+		return
+	}
+	defer conn.Close()
+
+	state := s.playback()
+
+	_, err = conn.Do("HMSET", PlaybackPrefix+s.id,
+		"schema", state.Schema,
+		"player", state.Player,
+		"item", state.Item,
+		"progress", state.Progress,
+		"playing", state.Playing,
+		"interrupted", state.Interrupted,
+		"updated_at", state.UpdatedAt,
+	)
+
+	if err != nil {
+		log.Println("Failed saving playback state", err)
+	}
+}
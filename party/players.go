@@ -0,0 +1,53 @@
+package party
+
+import (
+	"errors"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+	"dubclan/api/player/local"
+	"dubclan/api/player/spotify"
+
+	"golang.org/x/oauth2"
+)
+
+// PlayerFactory creates a player of a type for the party's host, the player publishes its events on bus
+type PlayerFactory func(playerType string, party *models.Party, bus *events.Bus) (player.Player, error)
+
+// NewPlayerFactory creates the players the API supports, spotify players refresh the host's token with spotifyConfig
+func NewPlayerFactory(spotifyConfig *oauth2.Config) PlayerFactory {
+	return func(playerType string, party *models.Party, bus *events.Bus) (player.Player, error) {
+		return newPlayer(spotifyConfig, playerType, party, bus)
+	}
+}
+
+func newPlayer(spotifyConfig *oauth2.Config, playerType string, party *models.Party, bus *events.Bus) (player.Player, error) {
+	if playerType == "local" {
+		// The host's client plays local tracks, no account needed
+		return local.New(bus), nil
+	}
+
+	token := party.Host.GetIdentityToken(playerType)
+
+	if token == nil {
+		return nil, errors.New("host has no " + playerType + " token")
+	}
+
+	switch playerType {
+	case "spotify":
+		var device *string
+		if id := party.Settings.Device; id != "" {
+			device = &id
+		}
+
+		p, err := spotify.New(bus, spotifyConfig, token, device)
+		if err != nil {
+			return nil, err
+		}
+
+		return p, nil
+	}
+
+	return nil, errors.New("unsupported player " + playerType)
+}
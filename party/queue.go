@@ -2,15 +2,32 @@ package party
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 
 	"dubclan/api/models"
 
 	"github.com/garyburd/redigo/redis"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// Times a change is retried when the queue is changed concurrently
+const maxQueueAttempts = 5
+
+var QueueConflict = errors.New("queue changed concurrently")
+
+// Queue is a snapshot of a party's queue, redis holds the source of truth.
+// Every change is applied atomically in redis and the snapshot replaced with the result.
 type Queue struct {
 	Items []models.Item `json:"items" bson:"items"`
+	// Incremented by every change to the queue in redis
+	Version int64 `json:"version" bson:"version"`
+	// Items are placed round-robin by who added them rather than by score alone
+	FairShare bool `json:"fair_share" bson:"fair_share"`
+
+	// Guards the snapshot, changes hold it until redis has replied
+	mutex sync.RWMutex
 }
 
 func NewQueue() *Queue {
@@ -20,26 +37,199 @@ func NewQueue() *Queue {
 }
 
 func ResumeQueue(conn redis.Conn, id string) (*Queue, error) {
-	if list, err := redis.Strings(conn.Do("LRANGE", QueuePrefix+id, 0, -1)); err == nil {
-		queue := NewQueue()
+	queue := NewQueue()
+
+	if err := queue.Refresh(conn, id); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	if queue.Version == 0 && len(queue.Items) == 0 {
+		return nil, redis.ErrNil
+	}
+
+	return queue, nil
+}
+
+// Refresh replaces the snapshot with the queue in redis
+func (q *Queue) Refresh(conn redis.Conn, id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	_, err := q.load(snapshotScript.Do(conn, QueuePrefix+id, QueueVersionPrefix+id))
+
+	return err
+}
+
+// Replace the snapshot with a script's result, returns whether the script applied its change
+func (q *Queue) load(reply interface{}, err error) (bool, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return false, err
+	}
+
+	if len(values) != 3 {
+		return false, errors.New("invalid queue snapshot")
+	}
+
+	applied, err := redis.Bool(values[0], nil)
+	if err != nil {
+		return false, err
+	}
+
+	version, err := redis.Int64(values[1], nil)
+	if err != nil {
+		return false, err
+	}
 
+	list, err := redis.Strings(values[2], nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Items already in the snapshot are updated in place, the player and the session hold on to them
+	existing := make(map[bson.ObjectId]models.Item, len(q.Items))
+	for _, item := range q.Items {
+		existing[item.GetID()] = item
+	}
+
+	// The head of the queue is the right end of the list
+	items := make([]models.Item, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
 		u := &models.ItemUnpacker{}
-		for i := len(list) - 1; i >= 0; i-- {
-			if err := json.Unmarshal([]byte(list[i]), u); err == nil {
-				queue.Items = append(queue.Items, u.Result)
-			} else {
-				log.Println(err)
-				return nil, err
+		if err := json.Unmarshal([]byte(list[i]), u); err != nil {
+			return false, err
+		}
+
+		item := u.Result
+		if current, ok := existing[item.GetID()]; ok && current.GetType() == item.GetType() {
+			current.Merge(item)
+			item = current
+		}
+
+		items = append(items, item)
+	}
+
+	q.Items = items
+	q.Version = version
+
+	return applied, nil
+}
+
+// Apply a change to copies of the items and save the result if the queue hasn't changed since,
+// otherwise the change is retried against the latest snapshot. The snapshot's items only take
+// on the change once it's saved.
+func (q *Queue) modify(conn redis.Conn, id string, change func(items []models.Item) ([]models.Item, error)) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for attempt := 0; attempt < maxQueueAttempts; attempt++ {
+		items, err := copyItems(q.Items)
+		if err != nil {
+			return err
+		}
+
+		items, err = change(items)
+		if err != nil {
+			return err
+		}
+
+		args := redis.Args{}.Add(QueuePrefix+id, QueueVersionPrefix+id, q.Version)
+		for i := len(items) - 1; i >= 0; i-- {
+			serialized, err := json.Marshal(items[i])
+			if err != nil {
+				return err
 			}
+
+			args = args.Add(serialized)
 		}
 
-		return queue, nil
-	} else {
-		return nil, err
+		if applied, err := q.load(replaceScript.Do(conn, args...)); err != nil {
+			return err
+		} else if applied {
+			return nil
+		}
 	}
+
+	return QueueConflict
+}
+
+// Copies of items which can be changed without changing the originals
+func copyItems(items []models.Item) ([]models.Item, error) {
+	copies := make([]models.Item, 0, len(items))
+
+	for _, item := range items {
+		serialized, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		u := &models.ItemUnpacker{}
+		if err := json.Unmarshal(serialized, u); err != nil {
+			return nil, err
+		}
+
+		copies = append(copies, u.Result)
+	}
+
+	return copies, nil
+}
+
+// MarshalJSON encodes the snapshot, it mustn't be encoded while it's being replaced
+func (q *Queue) MarshalJSON() ([]byte, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	return json.Marshal(struct {
+		Items     []models.Item `json:"items"`
+		Version   int64         `json:"version"`
+		FairShare bool          `json:"fair_share"`
+	}{q.Items, q.Version, q.FairShare})
+}
+
+// Head returns the item at the head of the queue, nil when the queue is empty
+func (q *Queue) Head() models.Item {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if len(q.Items) == 0 {
+		return nil
+	}
+
+	return q.Items[0]
+}
+
+func (q *Queue) Len() int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	return len(q.Items)
+}
+
+// Snapshot returns a copy of the queued items
+func (q *Queue) Snapshot() []models.Item {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	return append([]models.Item{}, q.Items...)
+}
+
+// Change the state of the item at index i, returns the item if change reports it changed
+func (q *Queue) ChangeItem(i int, change func(item models.Item) bool) models.Item {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if i < 0 || i >= len(q.Items) || !change(q.Items[i]) {
+		return nil
+	}
+
+	return q.Items[i]
 }
 
 func (q *Queue) GetNextPlayableList() []models.Item {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
 	firstType := ""
 	var items []models.Item
 	for _, item := range q.Items {
@@ -57,47 +247,287 @@ func (q *Queue) GetNextPlayableList() []models.Item {
 	return items
 }
 
-func (q *Queue) Push(conn redis.Conn, id string, item models.Item) error {
-	if serialized, err := json.Marshal(item); err == nil {
-		_, err := conn.Do("LPUSH", QueuePrefix+id, serialized)
+// Push adds an item to the queue, placed by score among the items after the first fixed items
+func (q *Queue) Push(conn redis.Conn, id string, item models.Item, fixed int) error {
+	return q.modify(conn, id, func(items []models.Item) ([]models.Item, error) {
+		return insertItem(items, item, q.place(items, item, fixed)), nil
+	})
+}
+
+// Vote on a pending item and move it to its place by score
+func (q *Queue) Vote(conn redis.Conn, id string, itemId, by bson.ObjectId, vote int, fixed int) error {
+	return q.modify(conn, id, func(items []models.Item) ([]models.Item, error) {
+		i := indexOf(items, itemId)
+		if i < 0 {
+			return nil, ItemNotFound
+		} else if i < fixed {
+			return nil, ItemPlaying
+		}
+
+		item := items[i]
+		item.Vote(by, vote)
 
-		if err == nil {
-			q.Items = append(q.Items, item)
+		rest := removeItem(items, i)
+		return insertItem(rest, item, q.place(rest, item, fixed)), nil
+	})
+}
+
+// Move a pending item to a new position
+func (q *Queue) Move(conn redis.Conn, id string, itemId bson.ObjectId, position int, fixed int) error {
+	return q.modify(conn, id, func(items []models.Item) ([]models.Item, error) {
+		i := indexOf(items, itemId)
+		if i < 0 {
+			return nil, ItemNotFound
 		}
 
-		return err
-	} else {
-		return err
+		if position >= len(items) {
+			position = len(items) - 1
+		} else if position < 0 {
+			position = 0
+		}
+
+		if held := heldItems(items, fixed); i < held || position < held {
+			return nil, ItemPlaying
+		}
+
+		return insertItem(removeItem(items, i), items[i], position), nil
+	})
+}
+
+// Remove a pending item
+func (q *Queue) Remove(conn redis.Conn, id string, itemId bson.ObjectId, fixed int) (models.Item, error) {
+	var removed models.Item
+
+	err := q.modify(conn, id, func(items []models.Item) ([]models.Item, error) {
+		i := indexOf(items, itemId)
+		if i < 0 {
+			return nil, ItemNotFound
+		} else if i < fixed {
+			return nil, ItemPlaying
+		}
+
+		removed = items[i]
+		return removeItem(items, i), nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
+
+	return removed, nil
 }
 
-func (q *Queue) Pop(conn redis.Conn, id string) (models.Item, error) {
-	if raw, err := redis.String(conn.Do("RPOP", QueuePrefix+id)); err == nil {
-		_, q.Items = q.Items[0], q.Items[1:]
+// How many items at the front of items keep their place. The head is held while it's marked as
+// playing, the instance owning the player may have started it since fixed was counted.
+func heldItems(items []models.Item, fixed int) int {
+	if fixed < 1 && len(items) > 0 && items[0].GetState().Playing {
+		return 1
+	}
 
-		u := &models.ItemUnpacker{}
-		if err := json.Unmarshal([]byte(raw), u); err == nil {
-			return u.Result, nil
-		} else {
-			return nil, err
+	return fixed
+}
+
+// Find the index of the item with the given id, -1 if it isn't queued
+func (q *Queue) IndexOf(itemId bson.ObjectId) int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	return indexOf(q.Items, itemId)
+}
+
+func indexOf(items []models.Item, itemId bson.ObjectId) int {
+	for i, item := range items {
+		if item.GetID() == itemId {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Whether item a should play before item b, by score then by when they were added
+func ranksAhead(a, b models.Item) bool {
+	if a.GetScore() != b.GetScore() {
+		return a.GetScore() > b.GetScore()
+	}
+
+	return a.GetAddedAt().Before(b.GetAddedAt())
+}
+
+// Find where an item belongs in items, the first fixed items keep their place
+func (q *Queue) place(items []models.Item, item models.Item, fixed int) int {
+	if q.FairShare {
+		return placeFair(items, item, fixed)
+	}
+
+	return placeItem(items, item, fixed)
+}
+
+// Find where an item belongs by score
+func placeItem(items []models.Item, item models.Item, fixed int) int {
+	if fixed > len(items) {
+		fixed = len(items)
+	} else if fixed < 0 {
+		fixed = 0
+	}
+
+	for i := fixed; i < len(items); i++ {
+		if ranksAhead(item, items[i]) {
+			return i
 		}
-	} else {
+	}
+
+	return len(items)
+}
+
+// Find where an item belongs when taking turns between attendees. Each attendee's pending items are
+// numbered into rounds by when they were added, an item plays after every item of an earlier round
+// and is ordered by score within its own round.
+func placeFair(items []models.Item, item models.Item, fixed int) int {
+	if fixed > len(items) {
+		fixed = len(items)
+	} else if fixed < 0 {
+		fixed = 0
+	}
+
+	addedBy := item.GetAddedBy()
+
+	round := 0
+	for _, pending := range items[fixed:] {
+		if pending.GetAddedBy() == addedBy && pending.GetAddedAt().Before(item.GetAddedAt()) {
+			round++
+		}
+	}
+
+	rounds := make(map[bson.ObjectId]int)
+	for i := fixed; i < len(items); i++ {
+		by := items[i].GetAddedBy()
+		itemRound := rounds[by]
+		rounds[by]++
+
+		if itemRound > round || (itemRound == round && ranksAhead(item, items[i])) {
+			return i
+		}
+	}
+
+	return len(items)
+}
+
+// Copy of items with item inserted at index i
+func insertItem(items []models.Item, item models.Item, i int) []models.Item {
+	result := make([]models.Item, 0, len(items)+1)
+	result = append(result, items[:i]...)
+	result = append(result, item)
+	return append(result, items[i:]...)
+}
+
+// Copy of items without the item at index i
+func removeItem(items []models.Item, i int) []models.Item {
+	result := make([]models.Item, 0, len(items))
+	result = append(result, items[:i]...)
+	return append(result, items[i+1:]...)
+}
+
+// Pop moves the head of the queue into the party's history, the head's state is recorded as it is in the snapshot
+func (q *Queue) Pop(conn redis.Conn, id string) (models.Item, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.Items) == 0 {
+		return nil, EmptyQueue
+	}
+
+	head := q.Items[0]
+
+	serialized, err := json.Marshal(head)
+	if err != nil {
 		return nil, err
 	}
+
+	applied, err := q.load(popScript.Do(conn, QueuePrefix+id, QueueVersionPrefix+id, HistoryPrefix+id, head.GetID().Hex(), serialized, MaxHistory))
+	if err != nil {
+		return nil, err
+	} else if !applied {
+		// The head was popped or replaced by someone else
+		return nil, QueueConflict
+	}
+
+	return head, nil
 }
 
 func (q *Queue) UpdateHead(conn redis.Conn, id string) error {
-	if serialized, err := json.Marshal(q.Items[0]); err == nil {
-		_, err := conn.Do("LSET", QueuePrefix+id, -1, serialized)
+	head := q.Head()
+	if head == nil {
+		return EmptyQueue
+	}
 
-		return err
-	} else {
+	return q.Update(conn, id, head)
+}
+
+// Update persists the state of a queued item
+func (q *Queue) Update(conn redis.Conn, id string, item models.Item) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	serialized, err := json.Marshal(item)
+	if err != nil {
 		return err
 	}
+
+	for attempt := 0; attempt < maxQueueAttempts; attempt++ {
+		i := indexOf(q.Items, item.GetID())
+		if i < 0 {
+			return ItemNotFound
+		}
+
+		if applied, err := q.load(updateScript.Do(conn, QueuePrefix+id, QueueVersionPrefix+id, i, item.GetID().Hex(), serialized)); err != nil {
+			return err
+		} else if applied {
+			return nil
+		}
+	}
+
+	return QueueConflict
+}
+
+// Restore moves the most recently finished item from the history back onto the head of the queue
+func (q *Queue) Restore(conn redis.Conn, id string) (models.Item, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for attempt := 0; attempt < maxQueueAttempts; attempt++ {
+		raw, err := redis.String(conn.Do("LINDEX", HistoryPrefix+id, 0))
+		if err == redis.ErrNil {
+			return nil, EmptyHistory
+		} else if err != nil {
+			return nil, err
+		}
+
+		u := &models.ItemUnpacker{}
+		if err := json.Unmarshal([]byte(raw), u); err != nil {
+			return nil, err
+		}
+
+		item := u.Result
+		item.UpdateState(models.ItemState{})
+
+		serialized, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		if applied, err := q.load(restoreScript.Do(conn, QueuePrefix+id, QueueVersionPrefix+id, HistoryPrefix+id, raw, serialized)); err != nil {
+			return nil, err
+		} else if applied {
+			return item, nil
+		}
+	}
+
+	return nil, QueueConflict
 }
 
 func (q *Queue) Delete(conn redis.Conn, id string) error {
-	_, err := conn.Do("DEL", QueuePrefix+id)
+	_, err := conn.Do("DEL", QueuePrefix+id, QueueVersionPrefix+id, HistoryPrefix+id)
 
 	return err
 }
@@ -0,0 +1,78 @@
+package party
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newTestQueue(t *testing.T, uris ...string) (*Queue, redis.Conn, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := redis.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := NewQueue()
+	for _, uri := range uris {
+		if err := queue.Push(conn, "test", newTestItem(bson.NewObjectId(), uri), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return queue, conn, server
+}
+
+// Items handed out by the queue see changes made after they were handed out
+func TestQueueUpdatesItemsInPlace(t *testing.T) {
+	queue, conn, server := newTestQueue(t, "a", "b")
+	defer server.Close()
+	defer conn.Close()
+
+	held := queue.Snapshot()
+
+	// Another instance changed the queue, the vote is retried against its snapshot
+	if _, err := conn.Do("INCR", QueueVersionPrefix+"test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := queue.Vote(conn, "test", held[1].GetID(), bson.NewObjectId(), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	items := queue.Snapshot()
+	if items[0] != held[1] || items[1] != held[0] {
+		t.Fatal("expected the queue to keep the items it handed out")
+	}
+
+	if held[1].GetScore() != 1 || len(held[1].GetVotes()) != 1 {
+		t.Fatal("expected the vote to be counted once, got a score of", held[1].GetScore())
+	}
+}
+
+// Changes which aren't saved leave the queue's items alone
+func TestQueueUnsavedChangesLeaveItems(t *testing.T) {
+	queue, conn, server := newTestQueue(t, "a", "b")
+	defer server.Close()
+
+	held := queue.Snapshot()
+	conn.Close()
+
+	if err := queue.Vote(conn, "test", held[1].GetID(), bson.NewObjectId(), 1, 0); err == nil {
+		t.Fatal("expected the vote to fail without a connection")
+	}
+
+	if held[1].GetScore() != 0 || held[1].GetVotes() != nil {
+		t.Fatal("expected the item not to be voted on")
+	}
+
+	if items := queue.Snapshot(); items[0] != held[0] || items[1] != held[1] {
+		t.Fatal("expected the queue to be unchanged")
+	}
+}
@@ -0,0 +1,62 @@
+package party
+
+import (
+	"dubclan/api/models"
+	"dubclan/api/store"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Records are where parties and their hosts' accounts are kept, sessions only reach mongo through them
+type Records interface {
+	Party(id bson.ObjectId) (*models.Party, error)
+	// IDs of every party
+	PartyIDs() ([]bson.ObjectId, error)
+	RemoveParty(id bson.ObjectId) error
+	SetPartyDevice(id bson.ObjectId, device string) error
+	UpdateIdentityToken(userId bson.ObjectId, provider string, token *oauth2.Token) error
+}
+
+type mongoRecords struct {
+	mongo *store.MongoStore
+}
+
+func NewMongoRecords(mongo *store.MongoStore) Records {
+	return &mongoRecords{mongo: mongo}
+}
+
+func (r *mongoRecords) Party(id bson.ObjectId) (*models.Party, error) {
+	session, db := r.mongo.DB()
+	defer session.Close()
+
+	return models.PartyByID(db, id)
+}
+
+func (r *mongoRecords) PartyIDs() ([]bson.ObjectId, error) {
+	session, db := r.mongo.DB()
+	defer session.Close()
+
+	return models.PartyIDs(db)
+}
+
+func (r *mongoRecords) RemoveParty(id bson.ObjectId) error {
+	session, db := r.mongo.DB()
+	defer session.Close()
+
+	return db.C(models.PartyCollection).RemoveId(id)
+}
+
+func (r *mongoRecords) SetPartyDevice(id bson.ObjectId, device string) error {
+	session, db := r.mongo.DB()
+	defer session.Close()
+
+	return models.SetPartyDevice(db, id, device)
+}
+
+func (r *mongoRecords) UpdateIdentityToken(userId bson.ObjectId, provider string, token *oauth2.Token) error {
+	session, db := r.mongo.DB()
+	defer session.Close()
+
+	return models.UpdateIdentityToken(db, userId, provider, token)
+}
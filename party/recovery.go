@@ -0,0 +1,93 @@
+package party
+
+import (
+	"encoding/json"
+	"log"
+
+	"dubclan/api/models"
+	"dubclan/api/player"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recover resumes the session of every party so parties carry on after the API restarts.
+// Whichever instance takes a party's lease picks up its playback and timeout.
+func (r *Registry) Recover() error {
+	ids, err := r.records.PartyIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := r.Resume(id.Hex()); err != nil {
+			log.Println("Failed recovering party", id.Hex(), err)
+		}
+	}
+
+	log.Println("Recovered", len(ids), "parties")
+
+	return nil
+}
+
+// Pick up playback of the head of the queue, it may still be on the host's player after
+// the instance which was running the party went away
+func (s *Session) reconcile() {
+	if s.CurrentPlayer != nil {
+		return
+	}
+
+	head := s.queue.Head()
+	if head == nil {
+		return
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		log.Println("Failed loading playback state", err)
+		return
+	}
+
+	playback, err := LoadPlayback(conn, s.id)
+	conn.Close()
+
+	if err != nil {
+		log.Println("Failed loading playback state", err)
+		return
+	} else if playback.Item != head.GetID().Hex() {
+		// The head wasn't handed to a player
+		return
+	}
+
+	p, err := s.GetPlayerForItem(head)
+	if err == nil {
+		var restored bool
+		if restored, err = p.Restore(s.queue.GetNextPlayableList()); err == nil && restored {
+			s.CurrentPlayer = p
+			s.playerChanged()
+
+			if p.GetState() == player.PLAYING {
+				s.clearTimeout()
+			}
+
+			return
+		}
+	}
+
+	if err != nil {
+		log.Println("Failed restoring playback", err)
+	}
+
+	// The host's player has moved on, the head waits to be played again
+	if s.queue.ChangeItem(0, models.Item.Pause) != nil {
+		s.UpdateHead()
+
+		event, err := json.Marshal(gin.H{
+			"queue": s.queue,
+			"type":  "queue.change",
+		})
+
+		if err == nil {
+			s.writeToClients(event)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package party
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"dubclan/api/models"
+	"dubclan/api/store"
+
+	"github.com/garyburd/redigo/redis"
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Registry owns the party sessions running on this instance. Sessions are only created through it
+// and remove themselves from it once they're closed.
+type Registry struct {
+	records   Records
+	redis     *store.RedisStore
+	broker    *Broker
+	newPlayer PlayerFactory
+	spotify   *oauth2.Config
+	sessions  map[string]*Session
+	mutex     sync.Mutex
+}
+
+func NewRegistry(mongo *store.MongoStore, redisStore *store.RedisStore, spotifyConfig *oauth2.Config) *Registry {
+	return &Registry{
+		records:   NewMongoRecords(mongo),
+		redis:     redisStore,
+		broker:    NewBroker(redisStore),
+		newPlayer: NewPlayerFactory(spotifyConfig),
+		spotify:   spotifyConfig,
+		sessions:  make(map[string]*Session),
+	}
+}
+
+// Get the party's session if it's running on this instance
+func (r *Registry) Get(id string) (*Session, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+// Start a session for the party with the given queue, the running session is returned if there's one already
+func (r *Registry) Start(party *models.Party, queue *Queue) *Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := party.ID.Hex()
+
+	if session, ok := r.sessions[id]; ok {
+		return session
+	}
+
+	session := NewSession(party, queue, r.records, r.redis, r.broker, r.newPlayer, r.spotify, r.remove)
+	r.sessions[id] = session
+
+	return session
+}
+
+// Resume gets the party's session, starting it from the database when the party
+// isn't running on this instance yet
+func (r *Registry) Resume(id string) (*Session, error) {
+	if session, ok := r.Get(id); ok {
+		return session, nil
+	}
+
+	if !bson.IsObjectIdHex(id) {
+		return nil, mgo.ErrNotFound
+	}
+
+	partyRecord, err := r.records.Party(bson.ObjectIdHex(id))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.redis.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	queue, err := ResumeQueue(conn, id)
+	if err == redis.ErrNil {
+		queue = NewQueue()
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Another request may have started the session while this one was loading
+	return r.Start(partyRecord, queue), nil
+}
+
+// Close ends the party if its session is running on this instance
+func (r *Registry) Close(id string) bool {
+	session, ok := r.Get(id)
+	if ok {
+		session.Close()
+	}
+
+	return ok
+}
+
+// Listen delivers messages published by every instance to the sessions on this one
+func (r *Registry) Listen() {
+	for {
+		if err := r.broker.Listen(r.deliver); err != nil {
+			log.Println("Lost party events subscription", err)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (r *Registry) deliver(id string, msg Message) {
+	if session, ok := r.Get(id); ok {
+		session.Receive(msg)
+	}
+}
+
+// Called by sessions once they've stopped
+func (r *Registry) remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// The party may have been started again since
+	if session, ok := r.sessions[id]; ok && session.isClosed() {
+		delete(r.sessions, id)
+	}
+}
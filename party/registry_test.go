@@ -0,0 +1,316 @@
+package party
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"dubclan/api/models"
+	"dubclan/api/store"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/websocket"
+	"github.com/olahol/melody"
+	"github.com/zmb3/spotify"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// These tests are meant to be run with the race detector, go test -race ./party
+
+func newTestRegistry(t *testing.T) (*Registry, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRegistry(nil, store.NewRedisStore(100, "tcp", server.Addr(), ""), nil), server
+}
+
+func newTestParty() *models.Party {
+	party := models.NewParty(bson.NewObjectId(), "test", "code", models.Settings{
+		// Long enough to never time out during a test
+		Timeout: 3600,
+	})
+
+	return &party
+}
+
+// Serves websocket connections for test clients
+type testClients struct {
+	melody    *melody.Melody
+	server    *httptest.Server
+	connected chan *melody.Session
+}
+
+func newTestClients() *testClients {
+	clients := &testClients{
+		melody:    melody.New(),
+		connected: make(chan *melody.Session),
+	}
+
+	clients.melody.HandleConnect(func(s *melody.Session) {
+		clients.connected <- s
+	})
+
+	clients.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients.melody.HandleRequestWithKeys(w, r, map[string]interface{}{
+			"user_id": r.URL.Query().Get("user_id"),
+		})
+	}))
+
+	return clients
+}
+
+// Connect a client for the user
+func (c *testClients) connect(t *testing.T, userId string) *melody.Session {
+	url := "ws" + strings.TrimPrefix(c.server.URL, "http") + "/?user_id=" + userId
+
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return <-c.connected
+}
+
+func (c *testClients) Close() {
+	c.melody.Close()
+	c.server.Close()
+}
+
+func newTestItem(by bson.ObjectId, uri string) models.Item {
+	item := &models.SpotifyTrack{URI: spotify.URI("spotify:track:" + uri)}
+	item.Type = "spotify_track"
+	item.Added(by)
+
+	return item
+}
+
+// Stop a session as if another instance closed the party
+func stopTestSession(session *Session) {
+	session.Receive(Message{
+		Origin: "test",
+		Kind:   EventMessage,
+		Data:   json.RawMessage(`{"type":"party.close"}`),
+	})
+}
+
+func TestRegistryStartConcurrently(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	defer server.Close()
+
+	party := newTestParty()
+
+	var waiter sync.WaitGroup
+	sessions := make([]*Session, 50)
+
+	for i := range sessions {
+		waiter.Add(1)
+		go func(i int) {
+			defer waiter.Done()
+
+			// Every join loads its own copy of the party
+			record := *party
+			sessions[i] = registry.Start(&record, NewQueue())
+		}(i)
+	}
+
+	waiter.Wait()
+
+	for _, session := range sessions {
+		if session != sessions[0] {
+			t.Fatal("expected every join to get the same session")
+		}
+	}
+
+	if got, ok := registry.Get(party.ID.Hex()); !ok || got != sessions[0] {
+		t.Fatal("expected the session to be registered")
+	}
+
+	stopTestSession(sessions[0])
+
+	if _, ok := registry.Get(party.ID.Hex()); ok {
+		t.Fatal("expected the stopped session to be removed")
+	}
+}
+
+func TestSessionConcurrentPushes(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	defer server.Close()
+
+	session := registry.Start(newTestParty(), NewQueue())
+	defer stopTestSession(session)
+
+	testClients := newTestClients()
+	defer testClients.Close()
+
+	const attendees, pushes = 20, 5
+
+	var waiter sync.WaitGroup
+	for i := 0; i < attendees; i++ {
+		userId := bson.NewObjectId()
+		client := testClients.connect(t, userId.Hex())
+
+		waiter.Add(1)
+		go func(i int) {
+			defer waiter.Done()
+
+			session.ClientConnected(client)
+
+			for j := 0; j < pushes; j++ {
+				if err := session.Push(newTestItem(userId, strconv.Itoa(i*pushes+j))); err != nil {
+					t.Error(err)
+				}
+
+				if _, err := json.Marshal(session.GetQueue()); err != nil {
+					t.Error(err)
+				}
+			}
+
+			session.ClientDisconnected(client)
+		}(i)
+	}
+
+	waiter.Wait()
+
+	if length := session.GetQueue().Len(); length != attendees*pushes {
+		t.Fatalf("expected %d queued items, got %d", attendees*pushes, length)
+	}
+
+	seen := make(map[bson.ObjectId]bool)
+	for _, item := range session.GetQueue().Snapshot() {
+		if seen[item.GetID()] {
+			t.Fatal("item queued twice", item.GetID())
+		}
+		seen[item.GetID()] = true
+	}
+
+	conn, err := registry.redis.GetConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	queue, err := ResumeQueue(conn, session.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if queue.Version != attendees*pushes || len(queue.Items) != attendees*pushes {
+		t.Fatalf("expected redis to hold every push, got version %d with %d items", queue.Version, len(queue.Items))
+	}
+}
+
+func TestSessionConcurrentConnects(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	defer server.Close()
+
+	session := registry.Start(newTestParty(), NewQueue())
+	defer stopTestSession(session)
+
+	testClients := newTestClients()
+	defer testClients.Close()
+
+	clients := make([]*melody.Session, 50)
+	for i := range clients {
+		clients[i] = testClients.connect(t, bson.NewObjectId().Hex())
+	}
+
+	var waiter sync.WaitGroup
+	for _, client := range clients {
+		waiter.Add(1)
+		go func(client *melody.Session) {
+			defer waiter.Done()
+			session.ClientConnected(client)
+		}(client)
+	}
+
+	waiter.Wait()
+
+	// Half of the attendees leave while the others keep pushing
+	for i, client := range clients {
+		waiter.Add(1)
+		go func(i int, client *melody.Session) {
+			defer waiter.Done()
+
+			if i%2 == 0 {
+				session.ClientDisconnected(client)
+			} else {
+				userId := bson.ObjectIdHex(client.MustGet("user_id").(string))
+				if err := session.Push(newTestItem(userId, strconv.Itoa(i))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, client)
+	}
+
+	waiter.Wait()
+
+	conn, err := registry.redis.GetConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	active, err := redis.Int(conn.Do("SCARD", ActivePrefix+session.id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if active != len(clients)/2 {
+		t.Fatalf("expected %d active attendees, got %d", len(clients)/2, active)
+	}
+
+	if length := session.GetQueue().Len(); length != len(clients)/2 {
+		t.Fatalf("expected %d queued items, got %d", len(clients)/2, length)
+	}
+}
+
+func TestRegistryCloseConcurrently(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	defer server.Close()
+
+	testClients := newTestClients()
+	defer testClients.Close()
+
+	parties := make([]*models.Party, 10)
+	for i := range parties {
+		parties[i] = newTestParty()
+		registry.Start(parties[i], NewQueue())
+	}
+
+	var waiter sync.WaitGroup
+	for _, party := range parties {
+		id := party.ID.Hex()
+
+		for i := 0; i < 5; i++ {
+			client := testClients.connect(t, bson.NewObjectId().Hex())
+
+			waiter.Add(1)
+			go func(i int) {
+				defer waiter.Done()
+
+				if i%2 == 0 {
+					if session, ok := registry.Get(id); ok {
+						stopTestSession(session)
+					}
+				} else if session, ok := registry.Get(id); ok {
+					// Sessions being stopped still accept clients without failing
+					session.ClientConnected(client)
+				}
+			}(i)
+		}
+	}
+
+	waiter.Wait()
+
+	for _, party := range parties {
+		if _, ok := registry.Get(party.ID.Hex()); ok {
+			t.Fatal("expected every session to be removed", party.ID.Hex())
+		}
+	}
+}
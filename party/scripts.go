@@ -0,0 +1,107 @@
+package party
+
+import "github.com/garyburd/redigo/redis"
+
+// Queue mutations run as scripts so they're atomic. Each script returns a snapshot of the queue:
+// whether the change was applied, the queue's version and the redis list (tail first).
+// A change isn't applied when the queue no longer matches what the caller based it on,
+// the caller can retry against the returned snapshot.
+
+// Read the queue
+// KEYS: queue, version
+var snapshotScript = redis.NewScript(2, `
+return {1, tonumber(redis.call('GET', KEYS[2]) or 0), redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// Replace the queue's items if it's still at the expected version
+// KEYS: queue, version
+// ARGV: expected version, items tail first...
+var replaceScript = redis.NewScript(2, `
+local version = tonumber(redis.call('GET', KEYS[2]) or 0)
+if version ~= tonumber(ARGV[1]) then
+	return {0, version, redis.call('LRANGE', KEYS[1], 0, -1)}
+end
+
+redis.call('DEL', KEYS[1])
+for i = 2, #ARGV do
+	redis.call('RPUSH', KEYS[1], ARGV[i])
+end
+
+version = redis.call('INCR', KEYS[2])
+return {1, version, redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// Replace the item at an index if it's still the item with the expected id
+// KEYS: queue, version
+// ARGV: index from the head, item id, item
+var updateScript = redis.NewScript(2, `
+local index = -1 - tonumber(ARGV[1])
+local current = redis.call('LINDEX', KEYS[1], index)
+if not current or (cjson.decode(current)['id'] or '') ~= ARGV[2] then
+	return {0, tonumber(redis.call('GET', KEYS[2]) or 0), redis.call('LRANGE', KEYS[1], 0, -1)}
+end
+
+redis.call('LSET', KEYS[1], index, ARGV[3])
+
+local version = redis.call('INCR', KEYS[2])
+return {1, version, redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// Move the head into the history if it's still the item with the expected id
+// KEYS: queue, version, history
+// ARGV: item id, finished item, history length
+var popScript = redis.NewScript(3, `
+local head = redis.call('LINDEX', KEYS[1], -1)
+if not head or (cjson.decode(head)['id'] or '') ~= ARGV[1] then
+	return {0, tonumber(redis.call('GET', KEYS[2]) or 0), redis.call('LRANGE', KEYS[1], 0, -1)}
+end
+
+redis.call('RPOP', KEYS[1])
+redis.call('LPUSH', KEYS[3], ARGV[2])
+redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[3]) - 1)
+
+local version = redis.call('INCR', KEYS[2])
+return {1, version, redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// Move the most recent history item onto the head if it's still the expected item
+// KEYS: queue, version, history
+// ARGV: expected history item, item to restore
+var restoreScript = redis.NewScript(3, `
+if redis.call('LINDEX', KEYS[3], 0) ~= ARGV[1] then
+	return {0, tonumber(redis.call('GET', KEYS[2]) or 0), redis.call('LRANGE', KEYS[1], 0, -1)}
+end
+
+redis.call('LPOP', KEYS[3])
+redis.call('RPUSH', KEYS[1], ARGV[2])
+
+local version = redis.call('INCR', KEYS[2])
+return {1, version, redis.call('LRANGE', KEYS[1], 0, -1)}
+`)
+
+// Take or extend a lease if it's free or already held by the instance
+// KEYS: lease
+// ARGV: instance, ttl in milliseconds
+var renewLeaseScript = redis.NewScript(1, `
+local owner = redis.call('GET', KEYS[1])
+if not owner then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+elseif owner == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+
+return 0
+`)
+
+// Give up a lease if it's held by the instance
+// KEYS: lease
+// ARGV: instance
+var releaseLeaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+
+return 0
+`)
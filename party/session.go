@@ -9,176 +9,211 @@ import (
 	"sync"
 	"time"
 
+	"dubclan/api/events"
 	"dubclan/api/models"
 	"dubclan/api/player"
-	"dubclan/api/player/spotify"
 	"dubclan/api/store"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/gin-gonic/gin"
 	"github.com/olahol/melody"
-	"github.com/olebedev/emitter"
+	"golang.org/x/oauth2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 const (
-	QueuePrefix    = "queue:"
-	JoinCodePrefix = "join_code:"
+	QueuePrefix        = "queue:"
+	QueueVersionPrefix = "queue_version:"
+	HistoryPrefix      = "history:"
+	PushRatePrefix     = "push_rate:"
+	JoinCodePrefix     = "join_code:"
+	EventsPrefix       = "party_events:"
+	OwnerPrefix        = "party_owner:"
+	PlayingPrefix      = "playing_items:"
+	ActivePrefix       = "active:"
+	SkipVotesPrefix    = "skip_votes:"
+	PlaybackPrefix     = "playback:"
 )
 
 var (
 	EmptyQueue = errors.New("empty queue")
 
+	EmptyHistory = errors.New("empty history")
+
 	Interrupted = errors.New("playback interrupted")
 
 	ConnectTokenIssued = errors.New("connect token is issued for this user")
+
+	VoteSkipDisabled = errors.New("vote skipping is disabled")
+
+	AlreadyVoted = errors.New("already voted to skip this item")
+
+	ItemNotFound = errors.New("item not found in queue")
+
+	ItemPlaying = errors.New("item is handed to the player")
+
+	NotPermitted = errors.New("not permitted")
+
+	QueueLimitReached = errors.New("queue is full")
+
+	AttendeeLimitReached = errors.New("attendee has too many pending items")
+
+	PushRateLimited = errors.New("attendee is pushing too fast")
+
+	DuplicatePending = errors.New("item is already queued")
+
+	DuplicateRecent = errors.New("item was played recently")
+
+	SessionClosed = errors.New("party session is closed")
 )
 
 type Session struct {
-	mongo         *store.MongoStore
+	records       Records
 	redis         *store.RedisStore
+	broker        *Broker
+	id            string
 	party         *models.Party
-	clients       map[string]*melody.Session
+	partyMutex    sync.RWMutex
+	clients       map[string]*melody.Session // Only used by the session's loop
 	queue         *Queue
 	players       map[string]player.Player
 	CurrentPlayer player.Player
-	emitter       *emitter.Emitter
+	bus           *events.Bus // The players' events
+	newPlayer     PlayerFactory
+	spotifyConfig *oauth2.Config // Refreshes the host's token when their devices are listed
+	commands      chan request
+	lastProgress  time.Time // When clients were last sent the player's progress
+	reauth        bool      // The host's token was rejected, players are created from a reloaded party
 	timeout       *time.Timer
 	timeoutMutex  sync.Mutex
+	owner         bool // Whether this instance holds the party's lease
+	ownerMutex    sync.Mutex
+	closed        bool
+	closeMutex    sync.Mutex
 
 	stop     chan bool
 	waiter   sync.WaitGroup
 	onClosed func(id string)
 }
 
-func NewSession(party *models.Party, queue *Queue, mongo *store.MongoStore, redisStore *store.RedisStore, onClosed func(id string)) (*Session) {
+func NewSession(party *models.Party, queue *Queue, records Records, redisStore *store.RedisStore, broker *Broker, newPlayer PlayerFactory, spotifyConfig *oauth2.Config, onClosed func(id string)) (*Session) {
+	queue.FairShare = party.Settings.FairShare
+
 	session := &Session{
-		mongo:        mongo,
-		redis:        redisStore,
-		party:        party,
-		clients:      make(map[string]*melody.Session),
-		queue:        queue,
-		players:      make(map[string]player.Player),
-		emitter:      emitter.New(10),
-		stop:         make(chan bool),
-		onClosed:     onClosed,
-		timeoutMutex: sync.Mutex{},
+		records:       records,
+		redis:         redisStore,
+		broker:        broker,
+		id:            party.ID.Hex(),
+		party:         party,
+		clients:       make(map[string]*melody.Session),
+		queue:         queue,
+		players:       make(map[string]player.Player),
+		bus:           events.NewBus(),
+		newPlayer:     newPlayer,
+		spotifyConfig: spotifyConfig,
+		commands:      make(chan request, commandBuffer),
+		stop:          make(chan bool),
+		onClosed:      onClosed,
+		timeoutMutex:  sync.Mutex{},
 	}
 
-	session.setupTimeout()
-
 	session.waiter.Add(1)
-	go (func(stop <-chan bool) {
-		change := session.emitter.On("player.track_finished")
-		play := session.emitter.On("player.play")
-		interrupt := session.emitter.On("player.interrupted")
-		pause := session.emitter.On("player.pause")
-		for {
-			select {
-			case _, ok := <-change:
-				if ok {
-					log.Println("CHANGE")
-					conn, err := redisStore.GetConnection()
-
-					if err != nil {
-						panic(err)
-					}
-
-					_, err = session.queue.Pop(conn, session.party.ID.Hex())
-					conn.Close()
-
-					if session.Play() != nil {
-						session.setupTimeout()
-					} else {
-						session.UpdateHead()
-					}
-
-					event, err := json.Marshal(gin.H{
-						"queue": session.queue,
-						"type":  "queue.change",
-					})
-
-					session.writeToClients(event)
-				}
-				break
-			case _, ok := <-interrupt:
-				if ok {
-					session.setupTimeout()
-
-					log.Println("INTERRUPT")
-					event, _ := json.Marshal(map[string]interface{}{
-						"type": "player.interrupted",
-					})
-
-					session.writeToClients(event)
-				}
-				break
-
-			case _, ok := <-play:
-				if ok && len(session.queue.Items) > 0 {
-					session.queue.Items[0].Play()
-					log.Println("PLAY")
-
-					session.UpdateHead()
-
-					event, _ := json.Marshal(map[string]interface{}{
-						"type": "player.play",
-					})
-
-					session.writeToClients(event)
-				}
-				break
-
-			case _, ok := <-pause:
-				if ok && len(session.queue.Items) > 0 {
-					session.queue.Items[0].Pause()
-					log.Println("PAUSED")
-
-					session.UpdateHead()
-
-					event, _ := json.Marshal(map[string]interface{}{
-						"type": "player.pause",
-					})
-
-					session.writeToClients(event)
-				}
-				break
-
-			case <-stop:
-				session.waiter.Done()
-				return
-			}
-		}
-	})(session.stop)
+	go session.run(session.stop)
 
 	return session
 }
 
+// Publish an event to the party's clients on every instance
 func (s *Session) writeToClients(msg []byte) {
-	for id, client := range s.clients {
-		if writeErr := client.Write(msg); writeErr != nil {
-			if writeErr.Error() == "session is closed" {
-				delete(s.clients, id)
-			} else {
-				log.Println(writeErr)
-			}
-		}
+	s.publish(Message{
+		Kind: EventMessage,
+		Data: msg,
+	})
+}
+
+// Publish an event to one of the party's clients, wherever it's connected
+func (s *Session) writeToClient(userId string, msg []byte) {
+	s.publish(Message{
+		Kind: EventMessage,
+		To:   userId,
+		Data: msg,
+	})
+}
+
+func (s *Session) publish(msg Message) {
+	if err := s.broker.Publish(s.id, msg); err != nil {
+		// At least reach the clients on this instance
+		log.Println("Failed publishing party event", err)
+		s.deliver(msg)
 	}
 }
 
+// Start counting down to closing the inactive party. The deadline is kept in redis so the countdown
+// carries on from where it was when the party is resumed by a restarted or another instance.
 func (s *Session) setupTimeout() {
 	s.timeoutMutex.Lock()
 	if s.timeout == nil {
-		s.timeout = time.AfterFunc(time.Second*s.party.Settings.Timeout, func() {
+		remaining := s.timeoutRemaining()
+
+		s.timeout = time.AfterFunc(remaining, func() {
 			log.Println("Party timedout")
 			s.Close()
 		})
-		log.Println("Setup timeout")
+		log.Println("Setup timeout", remaining)
 	}
 	s.timeoutMutex.Unlock()
 }
 
+// Time left until the persisted deadline, a new deadline is persisted if there isn't one
+func (s *Session) timeoutRemaining() time.Duration {
+	timeout := time.Second * s.record().Settings.Timeout
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		log.Println("Failed loading timeout deadline", err)
+		return timeout
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+
+	// Keep an existing deadline
+	conn.Send("MULTI")
+	conn.Send("HSETNX", PlaybackPrefix+s.id, "deadline", unixMillis(deadline))
+	conn.Send("HGET", PlaybackPrefix+s.id, "deadline")
+
+	var reply int64
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err == nil {
+		reply, err = redis.Int64(values[1], nil)
+	}
+
+	if err != nil {
+		log.Println("Failed persisting timeout deadline", err)
+		return timeout
+	}
+
+	if remaining := time.Unix(0, reply*int64(time.Millisecond)).Sub(time.Now()); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// Stop the countdown, the party is active again
 func (s *Session) clearTimeout() {
+	s.stopTimeout()
+
+	if conn, err := s.redis.GetConnection(); err == nil {
+		if _, err := conn.Do("HDEL", PlaybackPrefix+s.id, "deadline"); err != nil {
+			log.Println("Failed clearing timeout deadline", err)
+		}
+		conn.Close()
+	}
+}
+
+// Stop counting down on this instance, the persisted deadline is kept for whoever runs the party next
+func (s *Session) stopTimeout() {
 	s.timeoutMutex.Lock()
 	if s.timeout != nil {
 		s.timeout.Stop()
@@ -192,10 +227,7 @@ func (s *Session) GetQueue() *Queue {
 	return s.queue
 }
 
-func (s *Session) ClientConnected(client *melody.Session) {
-	attendeeCount := len(s.clients)
-	log.Println("Connected to party with", attendeeCount, "other active attendees")
-
+func (s *Session) clientConnected(client *melody.Session) {
 	userId := client.MustGet("user_id").(string)
 
 	event, _ := json.Marshal(gin.H{
@@ -203,16 +235,43 @@ func (s *Session) ClientConnected(client *melody.Session) {
 		"user": userId,
 	})
 
-	s.writeToClients(event)
+	s.publish(Message{
+		Kind:   EventMessage,
+		Except: userId,
+		Data:   event,
+	})
 
+	attendeeCount := len(s.clients)
 	s.clients[userId] = client
+
+	log.Println("Connected to party with", attendeeCount, "other active attendees")
+
+	if conn, err := s.redis.GetConnection(); err == nil {
+		if _, err := conn.Do("SADD", ActivePrefix+s.id, userId); err != nil {
+			log.Println("Failed marking attendee active", err)
+		}
+		conn.Close()
+	}
+
+	s.checkSkipVotes()
 }
 
-func (s *Session) ClientDisconnected(client *melody.Session) {
+func (s *Session) clientDisconnected(client *melody.Session) {
 	userId := client.MustGet("user_id").(string)
-	delete(s.clients, userId)
 
+	// A newer connection from the same attendee replaces this one
+	if s.clients[userId] == client {
+		delete(s.clients, userId)
+	}
 	attendeeCount := len(s.clients)
+
+	if conn, err := s.redis.GetConnection(); err == nil {
+		if _, err := conn.Do("SREM", ActivePrefix+s.id, userId); err != nil {
+			log.Println("Failed marking attendee offline", err)
+		}
+		conn.Close()
+	}
+
 	log.Println("Left session with", attendeeCount, "other active attendees")
 
 	// Notify others this attendee has disconnected
@@ -222,16 +281,130 @@ func (s *Session) ClientDisconnected(client *melody.Session) {
 	})
 
 	s.writeToClients(event)
+
+	s.checkSkipVotes()
+}
+
+func (s *Session) push(item models.Item) error {
+	// Local tracks are played from the host's own library, only they know what's in it
+	if _, ok := item.(*models.LocalTrack); ok && item.GetAddedBy() != s.record().HostID {
+		return NotPermitted
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := s.checkPushLimits(conn, item); err != nil {
+		return err
+	}
+
+	if err := s.checkDuplicate(conn, item); err != nil {
+		return err
+	}
+
+	if err := s.queue.Push(conn, s.id, item, s.playingItems()); err != nil {
+		return err
+	}
+
+	if err := s.recordPush(conn, item); err != nil {
+		log.Println("Failed recording push", err)
+	}
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
+}
+
+func (s *Session) vote(userId bson.ObjectId, itemId bson.ObjectId, vote int) error {
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := s.queue.Vote(conn, s.id, itemId, userId, vote, s.playingItems()); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
+}
+
+func (s *Session) remove(userId bson.ObjectId, itemId bson.ObjectId) error {
+	items := s.queue.Snapshot()
+
+	i := indexOf(items, itemId)
+	if i < 0 {
+		return ItemNotFound
+	}
+
+	if userId != s.record().HostID && items[i].GetAddedBy() != userId {
+		return NotPermitted
+	}
+
+	fixed := s.playingItems()
+	if i == 0 && fixed > 0 {
+		return s.next()
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := s.queue.Remove(conn, s.id, itemId, fixed); err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return nil
 }
 
-func (s *Session) Push(item models.Item) error {
+func (s *Session) move(userId bson.ObjectId, itemId bson.ObjectId, position int) error {
+	if userId != s.record().HostID {
+		return NotPermitted
+	}
+
 	conn, err := s.redis.GetConnection()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	if err := s.queue.Push(conn, s.party.ID.Hex(), item); err != nil {
+	if err := s.queue.Move(conn, s.id, itemId, position, s.playingItems()); err != nil {
 		return err
 	}
 
@@ -249,14 +422,60 @@ func (s *Session) Push(item models.Item) error {
 	return nil
 }
 
+// Close ends the party on every instance
 func (s *Session) Close() {
-	// Unsubscribe emitter listeners
-	s.emitter.Off("*")
+	if !s.teardown() {
+		return
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err == nil {
+		s.queue.Delete(conn, s.id)
+		conn.Do("DEL", ActivePrefix+s.id, PlayingPrefix+s.id, PlaybackPrefix+s.id)
+		s.releaseLease(conn)
+		conn.Close()
+	}
+
+	if err := s.records.RemoveParty(s.record().ID); err != nil {
+		log.Println("Failed removing party", err)
+	}
+
+	// Close the session on the other instances
+	event, _ := json.Marshal(gin.H{
+		"type": "party.close",
+	})
+
+	if err := s.broker.Publish(s.id, Message{Kind: EventMessage, Data: event}); err != nil {
+		log.Println("Failed publishing party close", err)
+	}
+}
+
+func (s *Session) isClosed() bool {
+	s.closeMutex.Lock()
+	defer s.closeMutex.Unlock()
+
+	return s.closed
+}
+
+// Stop the session on this instance and disconnect its clients, returns false if it's already stopped
+func (s *Session) teardown() bool {
+	s.closeMutex.Lock()
+	if s.closed {
+		s.closeMutex.Unlock()
+		return false
+	}
+	s.closed = true
+	s.closeMutex.Unlock()
+
+	// Unsubscribe from the players' events
+	s.bus.Close()
 	// Signal goroutines to stop
 	close(s.stop)
 
 	s.waiter.Wait()
 
+	s.stopTimeout()
+
 	if s.CurrentPlayer != nil {
 		s.CurrentPlayer.Pause()
 	}
@@ -265,20 +484,11 @@ func (s *Session) Close() {
 		p.Stop()
 	}
 
-	conn, err := s.redis.GetConnection()
-	if err == nil {
-		s.queue.Delete(conn, s.party.ID.Hex())
-		conn.Close()
-	}
-
-	session, db := s.mongo.DB()
-	s.party.Remove(db)
-	session.Close()
-
 	event, _ := json.Marshal(gin.H{
 		"type": "party.close",
 	})
 
+	// The loop has stopped, nothing else uses the clients
 	for _, client := range s.clients {
 		if writeErr := client.Write(event); writeErr != nil {
 			log.Println(writeErr)
@@ -287,10 +497,16 @@ func (s *Session) Close() {
 		client.Close()
 	}
 
-	s.onClosed(s.party.ID.Hex())
+	s.onClosed(s.id)
+
+	return true
 }
 
-func (s *Session) Pause() (error) {
+func (s *Session) pause() (error) {
+	if !s.IsOwner() {
+		return s.forward("pause")
+	}
+
 	if s.CurrentPlayer != nil {
 		if s.CurrentPlayer.GetState() == player.INTERRUPTED {
 			return Interrupted
@@ -309,45 +525,148 @@ func (s *Session) Pause() (error) {
 	return nil
 }
 
-func (s *Session) Next() (error) {
-	//if s.CurrentPlayer != nil {
-	//	if !s.CurrentPlayer.HasItems() {
-	//		items := s.queue.GetNextPlayableList()
-	//		if len(items) > 0 {
-	//			player, err := s.GetPlayerForItem(items[0])
-	//			if err != nil {
-	//				return err
-	//			} else if player != s.CurrentPlayer {
-	//				s.CurrentPlayer = player
-	//			}
-	//
-	//			return s.CurrentPlayer.Play(items)
-	//		} else {
-	//			s.CurrentPlayer = nil
-	//		}
-	//	} else {
-	//		return s.CurrentPlayer.Next()
-	//	}
-	//}
-	panic("NOT IMPLEMENTED")
+func (s *Session) next() (error) {
+	if !s.IsOwner() {
+		return s.forward("next")
+	}
+
+	if s.queue.Len() == 0 {
+		return EmptyQueue
+	}
+
+	if s.CurrentPlayer != nil && s.CurrentPlayer.HasItems() {
+		if s.CurrentPlayer.GetState() == player.INTERRUPTED {
+			return Interrupted
+		}
+
+		if err := s.CurrentPlayer.Next(); err != nil {
+			return err
+		}
+	}
+
+	s.queue.ChangeItem(0, models.Item.Done)
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.queue.Pop(conn, s.id)
+	conn.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var playErr error
+	if s.CurrentPlayer != nil {
+		if s.CurrentPlayer.HasItems() {
+			// Skipped within the current player's list
+			s.queue.ChangeItem(0, models.Item.Play)
+			s.UpdateHead()
+		} else if playErr = s.play(); playErr == EmptyQueue {
+			// Nothing left to play
+			playErr = nil
+			s.setupTimeout()
+		}
+	}
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	return playErr
+}
+
+func (s *Session) previous() (error) {
+	if !s.IsOwner() {
+		return s.forward("previous")
+	}
+
+	if s.CurrentPlayer != nil && s.CurrentPlayer.GetState() == player.INTERRUPTED {
+		return Interrupted
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.queue.Restore(conn, s.id)
+	conn.Close()
+
+	if err == EmptyHistory {
+		// Nothing to go back to, replay the current item instead
+		if s.CurrentPlayer != nil && s.CurrentPlayer.HasItems() {
+			return s.CurrentPlayer.Previous()
+		}
+
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if s.CurrentPlayer != nil && s.CurrentPlayer.HasItems() {
+		// Drop the player's list so playback restarts from the restored item
+		if paused := s.queue.ChangeItem(1, models.Item.Pause); paused != nil {
+			conn, err := s.redis.GetConnection()
+			if err != nil {
+				return err
+			}
+
+			err = s.queue.Update(conn, s.id, paused)
+			conn.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+		s.CurrentPlayer.Stop()
+
+		if err := s.play(); err != nil {
+			return err
+		}
+	}
+
+	event, err := json.Marshal(gin.H{
+		"queue": s.queue,
+		"type":  "queue.change",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
 
 	return nil
 }
 
 func (s *Session) UpdateHead() (error) {
-	if len(s.queue.Items) > 0 {
+	if s.queue.Len() > 0 {
 		conn, err := s.redis.GetConnection()
 
 		if err != nil {
 			panic(err)
 		}
-		s.queue.UpdateHead(conn, s.party.ID.Hex())
+		s.queue.UpdateHead(conn, s.id)
 		conn.Close()
 	}
 	return nil
 }
 
-func (s *Session) Play() (error) {
+func (s *Session) play() (error) {
+	if !s.IsOwner() {
+		return s.forward("play")
+	}
+
 	if s.CurrentPlayer != nil {
 		if s.CurrentPlayer.HasItems() {
 			if err := s.CurrentPlayer.Resume(); err != nil {
@@ -404,23 +723,15 @@ func (s *Session) GetPlayerForItem(item models.Item) (player.Player, error) {
 	if s.players[playerType] != nil {
 		return s.players[playerType], nil
 	} else {
-		var (
-			p   player.Player
-			err error
-		)
-
-		token := s.party.Host.GetIdentityToken(playerType)
-
-		if token == nil {
-			return nil, errors.New("host has no " + playerType + " token")
-		}
-
-		switch playerType {
-		case "spotify":
-			p, err = spotify.New(s.emitter, token, nil)
-			break
+		if s.reauth {
+			// Pick up the token the host signed in again with
+			if err := s.reloadParty(); err != nil {
+				return nil, err
+			}
+			s.reauth = false
 		}
 
+		p, err := s.newPlayer(playerType, s.record(), s.bus)
 		if err != nil {
 			return nil, err
 		}
@@ -431,14 +742,31 @@ func (s *Session) GetPlayerForItem(item models.Item) (player.Player, error) {
 	}
 }
 
+// GetParty returns a copy of the party record, changes are saved to the database and picked up
+// by the session through AttendeesChanged
 func (s *Session) GetParty() *models.Party {
+	party := *s.record()
+	party.Attendees = append([]*models.Attendee{}, party.Attendees...)
+
+	return &party
+}
+
+// The party record shared by the session's goroutines, it's replaced rather than changed
+func (s *Session) record() *models.Party {
+	s.partyMutex.RLock()
+	defer s.partyMutex.RUnlock()
+
 	return s.party
 }
 
-func (s *Session) AttendeesChanged() error {
+func (s *Session) attendeesChanged() error {
+	if err := s.reloadParty(); err != nil {
+		return err
+	}
+
 	event, err := json.Marshal(gin.H{
 		"type":      "attendees.change",
-		"attendees": s.party.Attendees,
+		"attendees": s.record().Attendees,
 	})
 
 	if err != nil {
@@ -450,36 +778,38 @@ func (s *Session) AttendeesChanged() error {
 	return nil
 }
 
-func (s *Session) TransferHost(to models.User) error {
+func (s *Session) transferHost(to models.User) error {
 	// Dispose existing players, create new instances with the new host's tokens
-	// Notify the new host if they have a websocket connection
-	if s.CurrentPlayer != nil {
-		if err := s.CurrentPlayer.Pause(); err != nil {
-			log.Println("Error pausing previous host's player", err)
-		}
-		s.CurrentPlayer = nil
+	if s.IsOwner() {
+		s.transferPlayers()
+	} else if err := s.forward("transfer_host"); err != nil {
+		return err
 	}
 
-	for key, p := range s.players {
-		p.Stop()
-		delete(s.players, key)
+	// Notify the new host if they have a websocket connection
+	event, err := json.Marshal(gin.H{
+		"type": "host.promotion",
+		"host": to,
+	})
+
+	if err != nil {
+		return err
 	}
 
-	// Notify the new host if they have a websocket connection
-	if client, ok := s.clients[to.ID.Hex()]; ok {
-		event, err := json.Marshal(gin.H{
-			"type": "host.promotion",
-			"host": to,
-		})
+	s.writeToClient(to.ID.Hex(), event)
 
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		client.Write(event)
+// Stop playback with the previous host's players
+func (s *Session) transferPlayers() {
+	if s.CurrentPlayer != nil {
+		if err := s.CurrentPlayer.Pause(); err != nil {
+			log.Println("Error pausing previous host's player", err)
+		}
 	}
 
-	return nil
+	s.resetPlayers()
 }
 
 func InitiateConnect(redis redis.Conn, party models.Party, attendee bson.ObjectId) (string, error) {
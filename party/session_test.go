@@ -0,0 +1,515 @@
+package party
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+	"dubclan/api/player/mock"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Party records kept in memory instead of mongo
+type testRecords struct {
+	mutex   sync.Mutex
+	parties map[bson.ObjectId]models.Party
+	removed map[bson.ObjectId]bool
+}
+
+func newTestRecords() *testRecords {
+	return &testRecords{
+		parties: make(map[bson.ObjectId]models.Party),
+		removed: make(map[bson.ObjectId]bool),
+	}
+}
+
+func (r *testRecords) Party(id bson.ObjectId) (*models.Party, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	party, ok := r.parties[id]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+
+	return &party, nil
+}
+
+func (r *testRecords) PartyIDs() ([]bson.ObjectId, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var ids []bson.ObjectId
+	for id := range r.parties {
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (r *testRecords) RemoveParty(id bson.ObjectId) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.parties, id)
+	r.removed[id] = true
+
+	return nil
+}
+
+func (r *testRecords) SetPartyDevice(id bson.ObjectId, device string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	party, ok := r.parties[id]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+
+	party.Settings.Device = device
+	r.parties[id] = party
+
+	return nil
+}
+
+func (r *testRecords) UpdateIdentityToken(userId bson.ObjectId, provider string, token *oauth2.Token) error {
+	return nil
+}
+
+func (r *testRecords) transferHost(id bson.ObjectId, to models.User) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	party := r.parties[id]
+	party.HostID = to.ID
+	party.Host = &to
+	r.parties[id] = party
+}
+
+func (r *testRecords) wasRemoved(id bson.ObjectId) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.removed[id]
+}
+
+// A mock player and the host it was created for
+type testPlayer struct {
+	*mock.Player
+	host bson.ObjectId
+}
+
+// A session playing on mock players, with its party kept in memory and redis run by miniredis
+type testSession struct {
+	*Session
+	registry *Registry
+	server   *miniredis.Miniredis
+	records  *testRecords
+	players  chan testPlayer
+}
+
+func newTestSession(t *testing.T, timeout time.Duration) *testSession {
+	registry, server := newTestRegistry(t)
+
+	h := &testSession{
+		registry: registry,
+		server:   server,
+		records:  newTestRecords(),
+		players:  make(chan testPlayer, 10),
+	}
+
+	registry.records = h.records
+	registry.newPlayer = func(playerType string, party *models.Party, bus *events.Bus) (player.Player, error) {
+		p := mock.New(bus)
+		h.players <- testPlayer{p, party.HostID}
+
+		return p, nil
+	}
+
+	party := newTestParty()
+	party.Settings.Timeout = timeout
+	h.records.parties[party.ID] = *party
+
+	h.Session = registry.Start(party, NewQueue())
+
+	return h
+}
+
+func (h *testSession) Close() {
+	if !h.isClosed() {
+		stopTestSession(h.Session)
+	}
+	h.server.Close()
+}
+
+// The next player the session creates
+func (h *testSession) player(t *testing.T) testPlayer {
+	select {
+	case p := <-h.players:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("expected the session to create a player")
+	}
+
+	return testPlayer{}
+}
+
+func (h *testSession) push(t *testing.T, uris ...string) []models.Item {
+	var items []models.Item
+
+	for _, uri := range uris {
+		item := newTestItem(h.record().HostID, uri)
+		if err := h.Push(item); err != nil {
+			t.Fatal(err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// Run f on the session's loop, so it sees the session the way its commands do
+type testCommand func(s *Session)
+
+func (c testCommand) apply(s *Session) error {
+	c(s)
+	return nil
+}
+
+func inLoop(t *testing.T, session *Session, f func(s *Session)) {
+	if err := session.Do(testCommand(f)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Whether the item at the head of the queue is the item and is playing
+func headPlaying(t *testing.T, session *Session, item models.Item) bool {
+	playing := false
+
+	inLoop(t, session, func(s *Session) {
+		head := s.queue.Head()
+		playing = head != nil && head.GetID() == item.GetID() && head.GetState().Playing
+	})
+
+	return playing
+}
+
+func queueLength(t *testing.T, session *Session) int {
+	length := 0
+
+	inLoop(t, session, func(s *Session) {
+		length = s.queue.Len()
+	})
+
+	return length
+}
+
+func timeoutSet(t *testing.T, session *Session) bool {
+	set := false
+
+	inLoop(t, session, func(s *Session) {
+		s.timeoutMutex.Lock()
+		set = s.timeout != nil
+		s.timeoutMutex.Unlock()
+	})
+
+	return set
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting until", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionTrackFinishedPlaysNext(t *testing.T) {
+	session := newTestSession(t, 3600)
+	defer session.Close()
+
+	items := session.push(t, "a", "b")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := session.player(t)
+
+	eventually(t, "the first item plays", func() bool {
+		return headPlaying(t, session.Session, items[0])
+	})
+
+	p.Finish()
+
+	eventually(t, "the second item plays", func() bool {
+		return queueLength(t, session.Session) == 1 && headPlaying(t, session.Session, items[1])
+	})
+
+	conn, err := session.registry.redis.GetConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if played, err := redis.Int(conn.Do("LLEN", HistoryPrefix+session.id)); err != nil || played != 1 {
+		t.Fatal("expected the finished item in the party's history", played, err)
+	}
+
+	p.Finish()
+
+	eventually(t, "the queue is empty", func() bool {
+		return queueLength(t, session.Session) == 0
+	})
+
+	if !timeoutSet(t, session.Session) {
+		t.Fatal("expected the party to time out once nothing's left to play")
+	}
+}
+
+func TestSessionPushedItemsFollowPlayer(t *testing.T) {
+	session := newTestSession(t, 3600)
+	defer session.Close()
+
+	items := session.push(t, "a")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := session.player(t)
+
+	items = append(items, session.push(t, "b")...)
+
+	eventually(t, "the player gets the pushed item", func() bool {
+		return len(p.GetItems()) == 2
+	})
+
+	p.Finish()
+
+	eventually(t, "the pushed item plays", func() bool {
+		return headPlaying(t, session.Session, items[1])
+	})
+
+	if timeoutSet(t, session.Session) {
+		t.Fatal("expected the party not to time out while playing")
+	}
+}
+
+func TestSessionMoveKeepsPlayingHead(t *testing.T) {
+	session := newTestSession(t, 3600)
+	defer session.Close()
+
+	items := session.push(t, "a", "b", "c")
+	host := session.record().HostID
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	session.player(t)
+
+	eventually(t, "the first item plays", func() bool {
+		return headPlaying(t, session.Session, items[0])
+	})
+
+	if err := session.Move(host, items[2].GetID(), 0); err != ItemPlaying {
+		t.Fatal("expected moving an item in front of the playing head to fail, got", err)
+	}
+
+	if err := session.Move(host, items[0].GetID(), 2); err != ItemPlaying {
+		t.Fatal("expected moving the playing head to fail, got", err)
+	}
+
+	if err := session.Move(host, items[2].GetID(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	inLoop(t, session.Session, func(s *Session) {
+		conn, err := s.redis.GetConnection()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Another instance may not know the player started yet, the head is still held
+		if err := s.queue.Move(conn, s.id, items[1].GetID(), 0, 0); err != ItemPlaying {
+			t.Error("expected the head marked as playing to keep its place, got", err)
+		}
+
+		got := s.queue.Snapshot()
+		if len(got) != 3 || got[0].GetID() != items[0].GetID() || got[1].GetID() != items[2].GetID() {
+			t.Error("expected the pending items to be reordered behind the playing head")
+		}
+	})
+}
+
+func TestSessionInterruptedTimesOut(t *testing.T) {
+	session := newTestSession(t, 1)
+	defer session.Close()
+
+	session.push(t, "a")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	session.player(t).Interrupt()
+
+	eventually(t, "the party times out", func() bool {
+		_, running := session.registry.Get(session.id)
+		return !running
+	})
+
+	eventually(t, "the party's record is removed", func() bool {
+		return session.records.wasRemoved(session.record().ID)
+	})
+
+	if session.server.Exists(QueuePrefix + session.id) {
+		t.Fatal("expected the party's queue to be deleted")
+	}
+}
+
+func TestSessionPauseTimesOut(t *testing.T) {
+	session := newTestSession(t, 1)
+	defer session.Close()
+
+	session.push(t, "a")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := session.player(t)
+
+	if err := session.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.GetState() != player.PAUSED {
+		t.Fatal("expected the player to be paused")
+	}
+
+	eventually(t, "the party times out", func() bool {
+		_, running := session.registry.Get(session.id)
+		return !running
+	})
+}
+
+func TestSessionResumeClearsTimeout(t *testing.T) {
+	session := newTestSession(t, 1)
+	defer session.Close()
+
+	session.push(t, "a")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	session.player(t)
+
+	if err := session.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if _, running := session.registry.Get(session.id); !running {
+		t.Fatal("expected the resumed party not to time out")
+	}
+
+	if timeoutSet(t, session.Session) {
+		t.Fatal("expected the timeout to be cleared")
+	}
+}
+
+func TestSessionHostTransfer(t *testing.T) {
+	session := newTestSession(t, 3600)
+	defer session.Close()
+
+	host := session.record().HostID
+	items := session.push(t, "a")
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := session.player(t)
+	if first.host != host {
+		t.Fatal("expected the player to be created for the host")
+	}
+
+	eventually(t, "the item plays", func() bool {
+		return headPlaying(t, session.Session, items[0])
+	})
+
+	// As the party controller does when the host leaves
+	newHost := models.User{ID: bson.NewObjectId(), Username: "new host"}
+	session.records.transferHost(session.record().ID, newHost)
+
+	if err := session.AttendeesChanged(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.TransferHost(newHost); err != nil {
+		t.Fatal(err)
+	}
+
+	if !first.Stopped() {
+		t.Fatal("expected the previous host's player to be disposed of")
+	}
+
+	inLoop(t, session.Session, func(s *Session) {
+		if s.CurrentPlayer != nil {
+			t.Error("expected the session to have no player")
+		}
+	})
+
+	if err := session.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	if second := session.player(t); second.host != newHost.ID {
+		t.Fatal("expected the player to be created for the new host")
+	}
+}
+
+func TestSessionOnlyHostPushesLocalTracks(t *testing.T) {
+	session := newTestSession(t, 3600)
+	defer session.Close()
+
+	track := &models.LocalTrack{URL: "/music/a.mp3", Title: "a", Duration: 1000}
+	track.Type = "local_track"
+	track.Added(bson.NewObjectId())
+
+	if err := session.Push(track); err != NotPermitted {
+		t.Fatal("expected an attendee's local track to be rejected, got", err)
+	}
+
+	track.Added(session.record().HostID)
+
+	if err := session.Push(track); err != nil {
+		t.Fatal(err)
+	}
+
+	if length := queueLength(t, session.Session); length != 1 {
+		t.Fatal("expected the host's local track to be queued, got a queue of", length)
+	}
+}
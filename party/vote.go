@@ -0,0 +1,132 @@
+package party
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gin-gonic/gin"
+)
+
+// Skip votes outlive any reasonable track, they're cleared once the item is skipped
+const skipVotesTTL = 6 * 60 * 60
+
+// Votes to skip the head of the queue are kept in redis so every instance counts them,
+// keyed by the item so votes for a previous head are discarded
+func (s *Session) skipVotesKey() string {
+	head := s.queue.Head()
+	if head == nil {
+		return ""
+	}
+
+	return SkipVotesPrefix + s.id + ":" + head.GetID().Hex()
+}
+
+// Count the votes of connected attendees and the number of votes needed to skip
+func (s *Session) countSkipVotes(conn redis.Conn, key string) (int, int, error) {
+	activeKey := ActivePrefix + s.id
+
+	voters, err := redis.Strings(conn.Do("SINTER", key, activeKey))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	active, err := redis.Int(conn.Do("SCARD", activeKey))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if active < 1 {
+		active = 1
+	}
+
+	// Round up so a threshold is never met early
+	required := (active*s.record().Settings.SkipThreshold + 99) / 100
+	if required < 1 {
+		required = 1
+	}
+
+	return len(voters), required, nil
+}
+
+func (s *Session) voteSkip(userId string) error {
+	if s.record().Settings.SkipThreshold <= 0 {
+		return VoteSkipDisabled
+	}
+
+	key := s.skipVotesKey()
+	if key == "" {
+		return EmptyQueue
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	added, err := redis.Int(conn.Do("SADD", key, userId))
+	if err != nil {
+		return err
+	} else if added == 0 {
+		return AlreadyVoted
+	}
+
+	if _, err := conn.Do("EXPIRE", key, skipVotesTTL); err != nil {
+		return err
+	}
+
+	return s.checkSkipVotes()
+}
+
+// Broadcast the vote count for the current head and skip it once the threshold is met
+func (s *Session) checkSkipVotes() error {
+	if s.record().Settings.SkipThreshold <= 0 {
+		return nil
+	}
+
+	key := s.skipVotesKey()
+	if key == "" {
+		return nil
+	}
+
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if exists, err := redis.Bool(conn.Do("EXISTS", key)); err != nil || !exists {
+		return err
+	}
+
+	count, required, err := s.countSkipVotes(conn, key)
+	if err != nil {
+		return err
+	}
+
+	event, err := json.Marshal(gin.H{
+		"type":     "queue.vote_skip",
+		"votes":    count,
+		"required": required,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.writeToClients(event)
+
+	if count >= required {
+		// Only the instance that clears the votes skips the item
+		if cleared, err := redis.Int(conn.Do("DEL", key)); err != nil || cleared == 0 {
+			return err
+		}
+
+		log.Println("Vote skip threshold reached")
+
+		return s.next()
+	}
+
+	return nil
+}
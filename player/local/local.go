@@ -0,0 +1,378 @@
+// Package local plays tracks from a venue's own library. The host's client does the playing, it's told
+// what to play and from where over the websocket. The player keeps the playback clock itself and moves
+// on to the next track once the current one's duration has passed.
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+)
+
+const (
+	// Between progress events while a track plays
+	progressInterval = 5 * time.Second
+)
+
+var (
+	NotLocalTrack = errors.New("only local tracks can be played")
+)
+
+// Player keeps to itself which items it was handed like the other players, the session changes
+// their state when the player publishes its events
+type Player struct {
+	bus     *events.Bus
+	mutex   sync.Mutex
+	items   []models.Item
+	state   int
+	offset  int       // Milliseconds into the current item when the clock last started or stopped
+	started time.Time // When the clock last started
+	volume  int
+	stop    chan bool // Closed to stop the clock
+}
+
+func New(bus *events.Bus) *Player {
+	return &Player{
+		bus:    bus,
+		state:  player.READY,
+		volume: 100,
+	}
+}
+
+func (p *Player) Play(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch p.state {
+	case player.PLAYING:
+		return errors.New("already playing")
+	case player.PAUSED:
+		return p.resume()
+	case player.INTERRUPTED:
+		items = p.items
+	}
+
+	if len(items) == 0 {
+		return errors.New("no items to play")
+	}
+
+	for _, item := range items {
+		if _, ok := item.(*models.LocalTrack); !ok {
+			return NotLocalTrack
+		}
+	}
+
+	p.items = append([]models.Item{}, items...)
+	p.offset = 0
+	p.start()
+
+	return nil
+}
+
+func (p *Player) Resume() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.resume()
+}
+
+func (p *Player) resume() error {
+	switch p.state {
+	case player.PLAYING:
+		return errors.New("already playing")
+	case player.READY:
+		return errors.New("no items to resume")
+	}
+
+	p.start()
+
+	return nil
+}
+
+func (p *Player) Pause() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state != player.PLAYING {
+		return errors.New("not playing")
+	}
+
+	p.offset = p.position()
+	p.stopClock()
+	p.state = player.PAUSED
+
+	p.send("local.pause", map[string]interface{}{
+		"position": p.offset,
+	})
+	p.bus.Publish(events.Paused{})
+
+	return nil
+}
+
+func (p *Player) Next() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.items) == 0 {
+		return errors.New("no items to skip")
+	}
+
+	p.items = p.items[1:]
+	p.offset = 0
+
+	if len(p.items) == 0 {
+		p.halt()
+	} else {
+		p.playCurrent()
+	}
+
+	return nil
+}
+
+func (p *Player) Previous() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.seek(0)
+}
+
+func (p *Player) SetVolume(percent int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.volume = percent
+	p.send("local.volume", map[string]interface{}{
+		"volume": percent,
+	})
+
+	return nil
+}
+
+func (p *Player) Seek(position int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.seek(position)
+}
+
+func (p *Player) seek(position int) error {
+	if len(p.items) == 0 {
+		return errors.New("no item to seek")
+	}
+
+	if position >= p.current().Duration {
+		return errors.New("position past the end of the track")
+	}
+
+	p.offset = position
+
+	if p.state == player.PLAYING {
+		p.started = time.Now()
+		p.runClock()
+	}
+
+	p.send("local.seek", map[string]interface{}{
+		"position": position,
+	})
+
+	return nil
+}
+
+func (p *Player) HasItems() (bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.items) > 0
+}
+
+func (p *Player) GetItems() []models.Item {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]models.Item{}, p.items...)
+}
+
+func (p *Player) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state != player.READY {
+		p.halt()
+	}
+}
+
+func (p *Player) Sync(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(items) == 0 || len(p.items) == 0 || items[0].GetID() != p.items[0].GetID() {
+		return errors.New("items don't start with the current item")
+	}
+
+	for _, item := range items {
+		if _, ok := item.(*models.LocalTrack); !ok {
+			return NotLocalTrack
+		}
+	}
+
+	p.items = append([]models.Item{}, items...)
+
+	return nil
+}
+
+// Restore never finds the items playing, the clock went away with the instance running it.
+// The host's client is told to stop so the head of the queue can be played again.
+func (p *Player) Restore(items []models.Item) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.send("local.stop", nil)
+
+	return false, nil
+}
+
+func (p *Player) GetState() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.state
+}
+
+func (p *Player) GetProgress() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.position()
+}
+
+func (p *Player) current() *models.LocalTrack {
+	return p.items[0].(*models.LocalTrack)
+}
+
+// Milliseconds into the current item
+func (p *Player) position() int {
+	if p.state != player.PLAYING {
+		return p.offset
+	}
+
+	return p.offset + int(time.Since(p.started)/time.Millisecond)
+}
+
+// Start or resume playing the current item
+func (p *Player) start() {
+	p.playCurrent()
+	p.bus.Publish(events.TrackStarted{})
+}
+
+// Play the current item from offset on the host's client
+func (p *Player) playCurrent() {
+	track := p.current()
+
+	p.state = player.PLAYING
+	p.started = time.Now()
+	p.runClock()
+
+	p.send("local.play", map[string]interface{}{
+		"item":     track.GetID(),
+		"url":      track.URL,
+		"title":    track.Title,
+		"artist":   track.Artist,
+		"album":    track.Album,
+		"duration": track.Duration,
+		"position": p.offset,
+		"volume":   p.volume,
+	})
+}
+
+// Stop playback on the host's client and forget the items
+func (p *Player) halt() {
+	p.stopClock()
+
+	p.items = nil
+	p.offset = 0
+	p.state = player.READY
+
+	p.send("local.stop", nil)
+}
+
+// The current item ended, carry on with the next one
+func (p *Player) finish() {
+	p.items = p.items[1:]
+	p.offset = 0
+	p.bus.Publish(events.TrackFinished{})
+
+	if len(p.items) == 0 {
+		p.halt()
+	} else {
+		p.playCurrent()
+	}
+}
+
+// Start the clock for the rest of the current item, replacing the running one
+func (p *Player) runClock() {
+	p.stopClock()
+
+	stop := make(chan bool)
+	p.stop = stop
+
+	remaining := time.Duration(p.current().Duration-p.offset) * time.Millisecond
+	go p.clock(stop, remaining)
+}
+
+func (p *Player) stopClock() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *Player) clock(stop chan bool, remaining time.Duration) {
+	end := time.NewTimer(remaining)
+	defer end.Stop()
+
+	progress := time.NewTicker(progressInterval)
+	defer progress.Stop()
+
+	for {
+		select {
+		case <-end.C:
+			p.mutex.Lock()
+			// The clock may have been replaced while waiting for the lock
+			if p.stop == stop {
+				p.stop = nil
+				p.finish()
+			}
+			p.mutex.Unlock()
+
+			return
+		case <-progress.C:
+			p.mutex.Lock()
+			if p.stop == stop {
+				p.bus.Publish(events.Progress{Progress: p.position(), Duration: p.current().Duration})
+			}
+			p.mutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Tell the host's client what to do
+func (p *Player) send(msgType string, fields map[string]interface{}) {
+	msg := map[string]interface{}{
+		"type": msgType,
+	}
+
+	for key, value := range fields {
+		msg[key] = value
+	}
+
+	if data, err := json.Marshal(msg); err == nil {
+		p.bus.Publish(events.HostMessage{Message: data})
+	}
+}
@@ -0,0 +1,195 @@
+package local
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newTestTracks(durations ...int) []models.Item {
+	var items []models.Item
+
+	for i, duration := range durations {
+		item := &models.LocalTrack{
+			URL:      "/music/" + string('a'+rune(i)) + ".mp3",
+			Duration: duration,
+		}
+		item.Type = "local_track"
+		item.Added(bson.NewObjectId())
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// The next event published, messages for the host's client are decoded
+func next(t *testing.T, s *events.Subscription) (events.Event, map[string]interface{}) {
+	select {
+	case event := <-s.C:
+		if message, ok := event.(events.HostMessage); ok {
+			var msg map[string]interface{}
+			if err := json.Unmarshal(message.Message, &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			return event, msg
+		}
+
+		return event, nil
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+
+	return nil, nil
+}
+
+func expectMessage(t *testing.T, s *events.Subscription, msgType string) map[string]interface{} {
+	event, msg := next(t, s)
+	if msg == nil || msg["type"] != msgType {
+		t.Fatalf("expected a %s message for the host, got %#v", msgType, event)
+	}
+
+	return msg
+}
+
+func expectEvent(t *testing.T, s *events.Subscription, want events.Event) {
+	if event, _ := next(t, s); event != want {
+		t.Fatalf("expected %#v, got %#v", want, event)
+	}
+}
+
+func TestPlayerPlaysThroughItems(t *testing.T) {
+	bus := events.NewBus()
+	defer bus.Close()
+
+	published := bus.Subscribe()
+	p := New(bus)
+	items := newTestTracks(50, 50)
+
+	if err := p.Play(items); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := expectMessage(t, published, "local.play")
+	if msg["url"] != "/music/a.mp3" || msg["item"] != items[0].GetID().Hex() {
+		t.Fatal("expected the host to be told to play the first track, got", msg)
+	}
+	expectEvent(t, published, events.TrackStarted{})
+
+	expectEvent(t, published, events.TrackFinished{})
+	if msg := expectMessage(t, published, "local.play"); msg["url"] != "/music/b.mp3" {
+		t.Fatal("expected the host to be told to play the second track, got", msg)
+	}
+
+	expectEvent(t, published, events.TrackFinished{})
+	expectMessage(t, published, "local.stop")
+
+	if p.HasItems() || p.GetState() != player.READY {
+		t.Fatal("expected the player to be ready for more items")
+	}
+}
+
+func TestPlayerPauseStopsTheClock(t *testing.T) {
+	bus := events.NewBus()
+	defer bus.Close()
+
+	p := New(bus)
+	defer p.Stop()
+
+	if err := p.Play(newTestTracks(200)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	paused := p.GetProgress()
+	if paused < 20 {
+		t.Fatal("expected the track to have played for a while, got", paused)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	if p.GetState() != player.PAUSED || !p.HasItems() || p.GetProgress() != paused {
+		t.Fatal("expected the paused track not to end")
+	}
+
+	if err := p.Seek(100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if progress := p.GetProgress(); progress < 100 || progress > 150 {
+		t.Fatal("expected playback to resume where it was moved to, got", progress)
+	}
+
+	if err := p.Seek(200); err == nil {
+		t.Fatal("expected seeking past the end of the track to fail")
+	}
+}
+
+func TestPlayerNext(t *testing.T) {
+	bus := events.NewBus()
+	defer bus.Close()
+
+	p := New(bus)
+	defer p.Stop()
+
+	items := newTestTracks(60000, 60000)
+
+	if err := p.Play(items); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.GetItems(); len(got) != 1 || got[0] != items[1] || p.GetState() != player.PLAYING {
+		t.Fatal("expected the player to be playing the second track")
+	}
+
+	if err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.HasItems() || p.GetState() != player.READY {
+		t.Fatal("expected playback to stop after skipping the last track")
+	}
+}
+
+func TestPlayerOnlyPlaysLocalTracks(t *testing.T) {
+	bus := events.NewBus()
+	defer bus.Close()
+
+	p := New(bus)
+
+	track := &models.SpotifyTrack{URI: "spotify:track:a"}
+	track.Type = "spotify_track"
+
+	if err := p.Play([]models.Item{track}); err != NotLocalTrack {
+		t.Fatal("expected a spotify track to be refused, got", err)
+	}
+
+	if err := p.Play(newTestTracks(60000)); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if err := p.Sync(append(p.GetItems(), track)); err != NotLocalTrack {
+		t.Fatal("expected a spotify track to be refused, got", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Package mock provides a player for tests. Nothing plays, tests drive what happens on the host's
+// device by calling Finish, Interrupt and the like, which publish the same events a real player does.
+package mock
+
+import (
+	"errors"
+	"sync"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+)
+
+// Player keeps to itself which items it was handed, it never changes their state so tests
+// can drive it while a session is using it
+type Player struct {
+	bus      *events.Bus
+	mutex    sync.Mutex
+	items    []models.Item
+	state    int
+	progress int
+	volume   int
+	stopped  bool
+}
+
+func New(bus *events.Bus) *Player {
+	return &Player{
+		bus:    bus,
+		state:  player.READY,
+		volume: 100,
+	}
+}
+
+func (p *Player) Play(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch p.state {
+	case player.PLAYING:
+		return errors.New("already playing")
+	case player.PAUSED:
+		return p.resume()
+	case player.INTERRUPTED:
+		items = p.items
+	}
+
+	if len(items) == 0 {
+		return errors.New("no items to play")
+	}
+
+	p.items = append([]models.Item{}, items...)
+	p.progress = 0
+	p.state = player.PLAYING
+	p.stopped = false
+	p.bus.Publish(events.TrackStarted{})
+
+	return nil
+}
+
+func (p *Player) Resume() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.resume()
+}
+
+func (p *Player) resume() error {
+	switch p.state {
+	case player.PLAYING:
+		return errors.New("already playing")
+	case player.READY:
+		return errors.New("no items to resume")
+	}
+
+	p.state = player.PLAYING
+	p.bus.Publish(events.TrackStarted{})
+
+	return nil
+}
+
+func (p *Player) Pause() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state != player.PLAYING {
+		return errors.New("not playing")
+	}
+
+	p.state = player.PAUSED
+	p.bus.Publish(events.Paused{})
+
+	return nil
+}
+
+func (p *Player) Next() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.state == player.INTERRUPTED {
+		return errors.New("playback interrupted")
+	}
+
+	if len(p.items) == 0 {
+		return errors.New("no items to skip")
+	}
+
+	p.pop()
+
+	return nil
+}
+
+func (p *Player) Previous() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.items) == 0 {
+		return errors.New("no item to replay")
+	}
+
+	p.progress = 0
+
+	return nil
+}
+
+func (p *Player) SetVolume(percent int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.volume = percent
+
+	return nil
+}
+
+func (p *Player) Seek(position int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.items) == 0 {
+		return errors.New("no item to seek")
+	}
+
+	p.progress = position
+
+	return nil
+}
+
+func (p *Player) HasItems() (bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.items) > 0
+}
+
+func (p *Player) GetItems() []models.Item {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]models.Item{}, p.items...)
+}
+
+func (p *Player) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.items = nil
+	p.progress = 0
+	p.state = player.READY
+	p.stopped = true
+}
+
+func (p *Player) Sync(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(items) == 0 || len(p.items) == 0 || items[0].GetID() != p.items[0].GetID() {
+		return errors.New("items don't start with the current item")
+	}
+
+	p.items = append([]models.Item{}, items...)
+
+	return nil
+}
+
+// Restore never finds the items on the device
+func (p *Player) Restore(items []models.Item) (bool, error) {
+	return false, nil
+}
+
+func (p *Player) GetState() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.state
+}
+
+func (p *Player) GetProgress() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.progress
+}
+
+// Move on to the next item, playback stops after the last one
+func (p *Player) pop() {
+	p.items = p.items[1:]
+	p.progress = 0
+
+	if len(p.items) == 0 {
+		p.state = player.READY
+	}
+}
+
+// Finish ends the current item, the player carries on with the next one
+func (p *Player) Finish() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.items) == 0 {
+		return
+	}
+
+	p.pop()
+	p.bus.Publish(events.TrackFinished{})
+}
+
+// Interrupt plays something else on the host's device
+func (p *Player) Interrupt() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.state = player.INTERRUPTED
+	p.bus.Publish(events.Interrupted{})
+}
+
+// PauseElsewhere pauses playback from the host's device
+func (p *Player) PauseElsewhere() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.state = player.PAUSED
+	p.bus.Publish(events.Paused{})
+}
+
+// Progress moves playback of the current item to progress milliseconds into its duration
+func (p *Player) Progress(progress, duration int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.progress = progress
+	p.bus.Publish(events.Progress{Progress: progress, Duration: duration})
+}
+
+// Volume is the volume last set
+func (p *Player) Volume() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.volume
+}
+
+// Stopped reports whether the session disposed of the player
+func (p *Player) Stopped() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.stopped
+}
@@ -9,10 +9,24 @@ type Player interface {
 	Pause() (error)
 	Resume() (error)
 	Next() (error)
+	// Replay the current item from its beginning
 	Previous() (error)
+	// Change the volume of the device playing, in percent
+	SetVolume(percent int) (error)
+	// Move playback of the current item to position milliseconds
+	Seek(position int) (error)
 	HasItems() (bool)
+	// The items handed to the player which haven't finished
+	GetItems() ([]models.Item)
+	// Stop tracking playback and forget the current items
 	Stop()
+	// Make the items after the current one follow items, which start with the current item
+	Sync(items []models.Item) (error)
+	// Take over tracking items which may already be on the host's player, reports whether they are
+	Restore(items []models.Item) (bool, error)
 	GetState() (int)
+	// Milliseconds into the current item
+	GetProgress() (int)
 }
 
 type EventType int
@@ -0,0 +1,552 @@
+package spotify
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"dubclan/api/events"
+	"dubclan/api/models"
+	"dubclan/api/player"
+	"dubclan/api/player/spotify/spotifytest"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// These tests run the player against the fake spotify API in spotifytest. Polling is stopped
+// after every command so each test polls the fake itself, once it has scripted what happens.
+// TestPlayerPollsConcurrently leaves the poller running and is meant to be run with the race detector.
+
+func validToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "access-0",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+}
+
+func expiredToken() *oauth2.Token {
+	token := validToken()
+	token.Expiry = time.Now().Add(-time.Minute)
+
+	return token
+}
+
+func newTestPlayer(t *testing.T, token *oauth2.Token) (*Player, *spotifytest.Server, *events.Bus) {
+	server := spotifytest.NewServer()
+	requestContext = context.WithValue(context.Background(), oauth2.HTTPClient, server.Client())
+
+	bus := events.NewBus()
+	DefaultScheduler = NewScheduler(1000)
+
+	p, err := New(bus, newConfig("id", "secret"), token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, server, bus
+}
+
+func closeTestPlayer(p *Player, server *spotifytest.Server) {
+	p.Stop()
+	server.Close()
+	requestContext = context.Background()
+}
+
+func newTestTracks(uris ...string) []models.Item {
+	var items []models.Item
+
+	for _, uri := range uris {
+		item := &models.SpotifyTrack{URI: spotify.URI("spotify:track:" + uri)}
+		item.Type = "spotify_track"
+		item.Added(bson.NewObjectId())
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func play(t *testing.T, p *Player, items []models.Item) {
+	if err := p.Play(items); err != nil {
+		t.Fatal(err)
+	}
+
+	stopPolling(p)
+}
+
+func stopPolling(p *Player) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stopPolling()
+}
+
+func poll(t *testing.T, p *Player) *spotify.PlayerState {
+	state, err := p.refresh(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+// Wait for an event of the same type as want, skipping the others
+func expectEvent(t *testing.T, subscription *events.Subscription, want events.Event) events.Event {
+	timeout := time.After(time.Second)
+
+	for {
+		select {
+		case event := <-subscription.C:
+			if reflect.TypeOf(event) == reflect.TypeOf(want) {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("expected a %T event", want)
+			return nil
+		}
+	}
+}
+
+func expectURIs(t *testing.T, server *spotifytest.Server, uris ...string) {
+	got := server.Player().URIs
+	if len(got) != len(uris) {
+		t.Fatalf("expected spotify to play %v, got %v", uris, got)
+	}
+
+	for i, uri := range uris {
+		if got[i] != spotify.URI("spotify:track:"+uri) {
+			t.Fatalf("expected spotify to play %v, got %v", uris, got)
+		}
+	}
+}
+
+func TestPlayerPlays(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	played := bus.Subscribe()
+
+	play(t, p, newTestTracks("a", "b"))
+	expectEvent(t, played, events.TrackStarted{})
+
+	expectURIs(t, server, "a", "b")
+	if !server.Player().Playing {
+		t.Fatal("expected spotify to be playing")
+	}
+
+	poll(t, p)
+
+	if p.GetState() != player.PLAYING {
+		t.Fatal("expected the player to be playing, got", p.GetState())
+	}
+}
+
+func TestPlayerTrackEnds(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	finished := bus.Subscribe()
+	items := newTestTracks("a", "b")
+
+	play(t, p, items)
+	poll(t, p)
+
+	server.EndTrack()
+	poll(t, p)
+
+	expectEvent(t, finished, events.TrackFinished{})
+
+	if len(p.GetItems()) != 1 || p.current() != "spotify:track:b" {
+		t.Fatal("expected the player to be on the second track")
+	}
+
+	// The session completes the item when it's told the track finished
+	if items[0].GetState().Completed {
+		t.Fatal("expected the player to leave the first track's state alone")
+	}
+}
+
+func TestPlayerPollsConcurrently(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	finished := bus.Subscribe()
+	items := newTestTracks("a", "b", "c", "d")
+
+	if err := p.Play(items); err != nil {
+		t.Fatal(err)
+	}
+
+	// The poller finds a track ended about every second while the player is used as the session would
+	deadline := time.Now().Add(2500 * time.Millisecond)
+	ended := time.Now()
+
+	for time.Now().Before(deadline) {
+		if time.Since(ended) > 700*time.Millisecond {
+			server.EndTrack()
+			ended = time.Now()
+		}
+
+		if current := p.GetItems(); len(current) > 0 {
+			p.Sync(current)
+		}
+
+		p.GetState()
+		p.GetProgress()
+		p.HasItems()
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expectEvent(t, finished, events.TrackFinished{})
+
+	for _, item := range items {
+		if item.GetState() != (models.ItemState{}) {
+			t.Fatal("expected the player to leave the items' state alone")
+		}
+	}
+}
+
+func TestPlayerLastTrackEnds(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	finished := bus.Subscribe()
+
+	play(t, p, newTestTracks("a"))
+	poll(t, p)
+
+	server.EndTrack()
+	poll(t, p)
+
+	expectEvent(t, finished, events.TrackFinished{})
+
+	if p.HasItems() {
+		t.Fatal("expected the player to have no items left")
+	}
+
+	if p.GetState() != player.READY {
+		t.Fatal("expected the player to be ready for more items, got", p.GetState())
+	}
+
+	// The next items play straight away
+	play(t, p, newTestTracks("b"))
+	expectURIs(t, server, "b")
+}
+
+func TestPlayerInterrupted(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	interrupted := bus.Subscribe()
+
+	play(t, p, newTestTracks("a", "b"))
+	poll(t, p)
+
+	server.PlayElsewhere("phone", "spotify:track:other")
+	poll(t, p)
+
+	expectEvent(t, interrupted, events.Interrupted{})
+
+	if p.GetState() != player.INTERRUPTED {
+		t.Fatal("expected the player to be interrupted, got", p.GetState())
+	}
+
+	if err := p.Next(); err == nil {
+		t.Fatal("expected skipping an interrupted player to fail")
+	}
+}
+
+func TestPlayerPausedElsewhere(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	play(t, p, newTestTracks("a", "b"))
+	poll(t, p)
+
+	server.Advance(30000)
+	poll(t, p)
+
+	server.PauseElsewhere()
+	poll(t, p)
+
+	if p.GetState() != player.PAUSED {
+		t.Fatal("expected the player to be paused, got", p.GetState())
+	}
+
+	if p.GetProgress() != 30000 {
+		t.Fatal("expected the player to be 30s into the track, got", p.GetProgress())
+	}
+}
+
+func TestPlayerPauseResume(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	play(t, p, newTestTracks("a"))
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Player().Playing {
+		t.Fatal("expected spotify to be paused")
+	}
+
+	if err := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	stopPolling(p)
+
+	if !server.Player().Playing {
+		t.Fatal("expected spotify to be playing")
+	}
+}
+
+func TestPlayerNext(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	play(t, p, newTestTracks("a", "b"))
+
+	if err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	stopPolling(p)
+
+	if server.Player().Current() != "spotify:track:b" {
+		t.Fatal("expected spotify to skip to the second track")
+	}
+
+	if err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.HasItems() || server.Player().Playing {
+		t.Fatal("expected playback to stop after skipping the last track")
+	}
+}
+
+func TestPlayerSync(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	items := newTestTracks("a", "b", "c")
+
+	play(t, p, items[:2])
+
+	server.Advance(30000)
+	poll(t, p)
+
+	requests := server.Requests(spotifytest.Play)
+
+	// Spotify moves on to the same next item, the track isn't interrupted
+	if err := p.Sync(items); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next item changed, it's replaced once the current track is about to end
+	if err := p.Sync([]models.Item{items[0], items[2], items[1]}); err != nil {
+		t.Fatal(err)
+	}
+
+	poll(t, p)
+
+	if server.Requests(spotifytest.Play) != requests {
+		t.Fatal("expected spotify's tracks to be left alone mid-track")
+	}
+
+	server.Advance(spotifytest.DefaultDuration - 30000 - 3000)
+	poll(t, p)
+
+	expectURIs(t, server, "a", "c", "b")
+
+	if state := server.Player(); !state.Playing || state.Current() != "spotify:track:a" || state.Progress < spotifytest.DefaultDuration-3000 {
+		t.Fatal("expected spotify to carry on playing the first track, got", state)
+	}
+
+	server.EndTrack()
+	poll(t, p)
+
+	if p.current() != "spotify:track:c" || p.GetState() != player.PLAYING {
+		t.Fatal("expected the changed next item to play")
+	}
+
+	if err := p.Sync(newTestTracks("b")); err == nil {
+		t.Fatal("expected items without the current one to be rejected")
+	}
+}
+
+func TestPlayerSyncPaused(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	items := newTestTracks("a", "b")
+
+	play(t, p, items[:1])
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Sync(items); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the tracks would start playback, it waits until playback resumes
+	expectURIs(t, server, "a")
+	if server.Player().Playing {
+		t.Fatal("expected spotify to stay paused")
+	}
+
+	if err := p.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	stopPolling(p)
+
+	expectURIs(t, server, "a", "b")
+}
+
+func TestPlayerVolumeAndSeek(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	play(t, p, newTestTracks("a"))
+
+	if err := p.SetVolume(40); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Seek(60000); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := server.Player(); state.Volume != 40 || state.Progress != 60000 {
+		t.Fatal("expected spotify's volume and position to change, got", state)
+	}
+}
+
+func TestPlayerDevices(t *testing.T) {
+	p, server, bus := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	server.AddDevice("speaker", "Living room", "Speaker")
+
+	devices, err := Devices(bus, newConfig("id", "secret"), validToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 || devices[1].ID != "speaker" || devices[1].Name != "Living room" {
+		t.Fatal("expected the host's two devices, got", devices)
+	}
+
+	play(t, p, newTestTracks("a"))
+
+	if err := p.SetDevice("speaker"); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := server.Player(); state.Device != "speaker" || !state.Playing {
+		t.Fatal("expected playback to carry on on the speaker, got", state)
+	}
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SetDevice("unknown"); err == nil {
+		t.Fatal("expected transferring playback to an unknown device to fail")
+	}
+}
+
+func TestPlayerRateLimited(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	play(t, p, newTestTracks("a"))
+
+	server.Fail(spotifytest.State, 429, 1)
+
+	if _, err := p.refresh(nil); err == nil {
+		t.Fatal("expected the poll to be rate limited")
+	}
+
+	// Spotify asks for a second's wait, every request is held back until then
+	start := time.Now()
+
+	if err := p.SetVolume(40); err != nil {
+		t.Fatal(err)
+	}
+
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Fatal("expected the request to wait for the rate limit, waited", waited)
+	}
+}
+
+func TestPlayerControlsAreScheduled(t *testing.T) {
+	p, server, _ := newTestPlayer(t, validToken())
+	defer closeTestPlayer(p, server)
+
+	DefaultScheduler.SetBudget(10)
+	start := time.Now()
+
+	play(t, p, newTestTracks("a"))
+
+	if err := p.SetVolume(40); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Seek(1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Fatal("expected four requests to take three slots of a tenth of a second, took", waited)
+	}
+}
+
+func TestPlayerRefreshesToken(t *testing.T) {
+	p, server, bus := newTestPlayer(t, expiredToken())
+	defer closeTestPlayer(p, server)
+
+	refreshed := bus.Subscribe()
+
+	play(t, p, newTestTracks("a"))
+
+	event := expectEvent(t, refreshed, events.TokenRefreshed{}).(events.TokenRefreshed)
+	if event.Provider != "spotify" || event.Token.AccessToken != "access-1" {
+		t.Fatal("expected the refreshed token", event)
+	}
+
+	poll(t, p)
+
+	if server.Requests(spotifytest.Token) != 1 || server.AccessToken() != "access-1" {
+		t.Fatal("expected requests to be made with the token refreshed once")
+	}
+}
+
+func TestPlayerReauthRequired(t *testing.T) {
+	p, server, bus := newTestPlayer(t, expiredToken())
+	defer closeTestPlayer(p, server)
+
+	reauth := bus.Subscribe()
+
+	// The refresh is retried with the client's credentials sent another way, it's rejected both times
+	server.Fail(spotifytest.Token, 400, 2)
+
+	if err := p.Play(newTestTracks("a")); err == nil {
+		t.Fatal("expected playing without a valid token to fail")
+	}
+
+	expectEvent(t, reauth, events.ReauthRequired{})
+}
@@ -0,0 +1,236 @@
+package spotify
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"dubclan/api/store"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// Polls while a track is playing are spaced between these, sparse mid-track and close together near its end
+	MinPollInterval = 1 * time.Second
+	MaxPollInterval = 15 * time.Second
+	// Polls happen this long after a track is expected to end so spotify has moved on
+	trackEndMargin = 500 * time.Millisecond
+	// When the next item changed, spotify's tracks are replaced within this long of the current track's end
+	resyncLead = 5 * time.Second
+
+	// Backoff after failed polls, doubled for every failure in a row
+	minBackoff = 2 * time.Second
+	maxBackoff = time.Minute
+
+	// Requests per second to spotify across every party
+	DefaultRequestBudget = 10
+
+	// Holds when the next request to spotify can be made, in milliseconds since the epoch
+	scheduleKey = "spotify_schedule"
+)
+
+// Reserve the next request slot, returns how many milliseconds to wait for it
+// KEYS: schedule
+// ARGV: now, interval
+var reserveScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local slot = tonumber(redis.call('GET', KEYS[1]) or 0)
+if slot < now then
+	slot = now
+end
+
+local next = slot + tonumber(ARGV[2])
+redis.call('SET', KEYS[1], next, 'PX', next - now + 1000)
+return slot - now
+`)
+
+// Hold back every request until a time unless they're held back longer already
+// KEYS: schedule
+// ARGV: now, resume at
+var throttleScript = redis.NewScript(1, `
+local resume = tonumber(ARGV[2])
+if tonumber(redis.call('GET', KEYS[1]) or 0) < resume then
+	redis.call('SET', KEYS[1], resume, 'PX', resume - tonumber(ARGV[1]) + 1000)
+end
+return 0
+`)
+
+// Scheduler paces every request made to spotify so they stay within a request budget, rate limiting
+// by spotify holds back every player. Once shared through redis the budget applies across every
+// instance of the API.
+type Scheduler struct {
+	mutex    sync.Mutex
+	interval time.Duration     // Between requests
+	next     time.Time         // When the next request can be made, when the budget isn't shared
+	redis    *store.RedisStore // Holds the schedule shared with other instances
+}
+
+// The scheduler shared by every player
+var DefaultScheduler = NewScheduler(DefaultRequestBudget)
+
+func NewScheduler(budget int) *Scheduler {
+	s := &Scheduler{}
+	s.SetBudget(budget)
+
+	return s
+}
+
+// SetBudget sets the number of requests per second
+func (s *Scheduler) SetBudget(budget int) {
+	if budget < 1 {
+		budget = 1
+	}
+
+	s.mutex.Lock()
+	s.interval = time.Second / time.Duration(budget)
+	s.mutex.Unlock()
+}
+
+// Share the budget with every instance using the same redis
+func (s *Scheduler) Share(redisStore *store.RedisStore) {
+	s.mutex.Lock()
+	s.redis = redisStore
+	s.mutex.Unlock()
+}
+
+// Reserve the next request slot, returns how long to wait for it
+func (s *Scheduler) reserve() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.redis != nil {
+		wait, err := redis.Int64(s.shared(reserveScript, int64(s.interval/time.Millisecond)))
+		if err == nil {
+			return time.Duration(wait) * time.Millisecond
+		}
+
+		// Pace this instance's requests by themselves until redis is back
+		log.Println("Failed reserving a shared spotify request slot", err)
+	}
+
+	now := time.Now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+
+	slot := s.next
+	s.next = slot.Add(s.interval)
+
+	return slot.Sub(now)
+}
+
+// Wait for a request slot, returns false if stop is closed while waiting
+func (s *Scheduler) Wait(stop <-chan bool) bool {
+	delay := s.reserve()
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Throttle holds back every request for d, spotify limits requests per application
+func (s *Scheduler) Throttle(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if until := time.Now().Add(d); until.After(s.next) {
+		s.next = until
+	}
+
+	if s.redis != nil {
+		if _, err := s.shared(throttleScript, unixMillis(time.Now().Add(d))); err != nil {
+			log.Println("Failed throttling shared spotify requests", err)
+		}
+	}
+}
+
+// Run a script over the shared schedule
+func (s *Scheduler) shared(script *redis.Script, arg int64) (interface{}, error) {
+	conn, err := s.redis.GetConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return script.Do(conn, scheduleKey, unixMillis(time.Now()), arg)
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// scheduledTransport makes every request to spotify wait for its slot
+type scheduledTransport struct {
+	base      http.RoundTripper
+	scheduler *Scheduler
+}
+
+func (t *scheduledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.scheduler.Wait(nil)
+
+	res, err := t.base.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusTooManyRequests {
+		// The limit applies to every party
+		t.scheduler.Throttle(retryAfter(res))
+	}
+
+	return res, err
+}
+
+// How long spotify asks to wait after rate limiting a request
+func retryAfter(res *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return minBackoff
+}
+
+// How long to wait before polling again given the last player state, and whether spotify's tracks
+// are to be replaced before the current one ends
+func pollDelay(state *spotify.PlayerState, resync bool) time.Duration {
+	if state == nil || !state.Playing || state.Item == nil {
+		// Nothing's going to end, only changes made on the host's device can be missed
+		return MaxPollInterval
+	}
+
+	remaining := timeLeft(state)
+	if resync && remaining > resyncLead {
+		// Check once the track is within the time its successor is replaced in
+		remaining -= resyncLead
+	}
+
+	delay := MaxPollInterval
+	if remaining+trackEndMargin < delay {
+		// Check just after the track ends, or once its successor is due to be replaced
+		delay = remaining + trackEndMargin
+	}
+
+	if delay < MinPollInterval {
+		delay = MinPollInterval
+	}
+
+	return delay
+}
+
+// Time left in the track of a player state
+func timeLeft(state *spotify.PlayerState) time.Duration {
+	if state == nil || state.Item == nil {
+		return MaxPollInterval
+	}
+
+	return time.Duration(state.Item.Duration-state.Progress) * time.Millisecond
+}
@@ -0,0 +1,63 @@
+package spotify
+
+import (
+	"testing"
+	"time"
+
+	"dubclan/api/store"
+
+	"github.com/alicebob/miniredis"
+	"github.com/zmb3/spotify"
+)
+
+// Instances sharing redis take turns within one budget
+func TestSchedulerSharesBudget(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	redisStore := store.NewRedisStore(10, "tcp", server.Addr(), "")
+
+	a, b := NewScheduler(10), NewScheduler(10)
+	a.Share(redisStore)
+	b.Share(redisStore)
+
+	for i, s := range []*Scheduler{a, b, a, b} {
+		want := time.Duration(i) * 100 * time.Millisecond
+
+		// Allow for the time taken by the earlier reservations
+		if wait := s.reserve(); wait > want || wait < want-50*time.Millisecond {
+			t.Fatalf("expected reservation %d to wait %v, got %v", i, want, wait)
+		}
+	}
+
+	b.Throttle(time.Second)
+
+	if wait := a.reserve(); wait < 900*time.Millisecond {
+		t.Fatal("expected a throttled instance to hold back the others, got", wait)
+	}
+}
+
+func TestPollDelay(t *testing.T) {
+	state := playingState("a", 150000)
+
+	if delay := pollDelay(state, false); delay != MaxPollInterval {
+		t.Fatal("expected mid-track polls to be sparse, got", delay)
+	}
+
+	state.Progress = 170000
+
+	if delay := pollDelay(state, false); delay != 10*time.Second+trackEndMargin {
+		t.Fatal("expected a poll just after the track ends, got", delay)
+	}
+
+	if delay := pollDelay(state, true); delay != 5*time.Second+trackEndMargin {
+		t.Fatal("expected a poll once the next track is due to be replaced, got", delay)
+	}
+
+	if delay := pollDelay(&spotify.PlayerState{}, true); delay != MaxPollInterval {
+		t.Fatal("expected nothing playing to be polled rarely, got", delay)
+	}
+}
@@ -2,10 +2,12 @@ package spotify
 
 import (
 	"errors"
+	"context"
 	"log"
 	"sync"
 	"time"
 
+	"dubclan/api/events"
 	"dubclan/api/models"
 	"dubclan/api/player"
 
@@ -13,20 +15,17 @@ import (
 
 	"github.com/markbates/goth"
 	spotifyProvider "github.com/markbates/goth/providers/spotify"
-	"github.com/olebedev/emitter"
 	"github.com/urfave/cli"
 	"golang.org/x/oauth2"
 )
 
 var (
-	hostScopes    = []string{"user-library-read", "user-read-private", "user-read-playback-state", "user-modify-playback-state", "user-read-currently-playing"}
-	authenticator = spotify.NewAuthenticator("", hostScopes...)
+	hostScopes = []string{"user-library-read", "user-read-private", "user-read-playback-state", "user-modify-playback-state", "user-read-currently-playing"}
 
 	provider goth.Provider
-)
 
-const (
-	PollInterval = 5 * time.Second
+	// Context of the requests made to spotify, its HTTP client can be swapped for one talking to a fake API
+	requestContext = context.Background()
 )
 
 func InitProvider(callbackUrl string, cli *cli.Context) goth.Provider {
@@ -40,23 +39,94 @@ func InitProvider(callbackUrl string, cli *cli.Context) goth.Provider {
 	return provider
 }
 
+// NewConfig is the API's spotify client, players refresh hosts' tokens with its credentials
+func NewConfig(cli *cli.Context) *oauth2.Config {
+	return newConfig(cli.String("spotify-id"), cli.String("spotify-secret"))
+}
+
+func newConfig(clientId string, clientSecret string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+		Scopes:       hostScopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotify.AuthURL,
+			TokenURL: spotify.TokenURL,
+		},
+	}
+}
+
+// Player follows the host's spotify device from a polling goroutine, the mutex guards what it
+// shares with the session's commands. Items handed to it are only ever read.
 type Player struct {
-	emitter       *emitter.Emitter
+	bus           *events.Bus
+	mutex         sync.Mutex
 	client        spotify.Client
-	refreshing    sync.RWMutex // Locked whilst refreshing host's access token
 	playbackState *spotify.PlayerState
 	deviceId      *string
 	currentItems  []models.Item
-	stop          chan bool // Close this channel when a party becomes inactive
-	ticker        *time.Ticker
+	loaded        []spotify.URI // The tracks last handed to spotify
+	loadedAt      int           // Where the current item is in loaded
+	stop          chan bool     // Close this channel when a party becomes inactive
+	polledAt      time.Time
+	stale         bool // Spotify's tracks don't follow the current items, replaced when playback resumes
 	state         int
 }
 
-func New(emitter *emitter.Emitter, token *oauth2.Token, deviceId *string) (*Player, error) {
+// Device is one of the host's spotify devices playback can be sent to
+type Device struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Active     bool   `json:"active"`
+	Restricted bool   `json:"restricted"`
+}
+
+// A client for the host which refreshes their token when it expires
+func newClient(bus *events.Bus, config *oauth2.Config, token *oauth2.Token) spotify.Client {
+	source := &tokenSource{
+		config: config,
+		token:  token,
+		bus:    bus,
+	}
+
+	client := oauth2.NewClient(requestContext, source)
+	client.Transport = &scheduledTransport{
+		base:      client.Transport,
+		scheduler: DefaultScheduler,
+	}
+
+	return spotify.NewClient(client)
+}
+
+// Devices lists the host's devices which are online
+func Devices(bus *events.Bus, config *oauth2.Config, token *oauth2.Token) ([]Device, error) {
+	client := newClient(bus, config, token)
+
+	playerDevices, err := client.PlayerDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(playerDevices))
+	for _, device := range playerDevices {
+		devices = append(devices, Device{
+			ID:         string(device.ID),
+			Name:       device.Name,
+			Type:       device.Type,
+			Active:     device.Active,
+			Restricted: device.Restricted,
+		})
+	}
+
+	return devices, nil
+}
+
+func New(bus *events.Bus, config *oauth2.Config, token *oauth2.Token, deviceId *string) (*Player, error) {
 
 	return &Player{
-		emitter:       emitter,
-		client:        authenticator.NewClient(token),
+		bus:           bus,
+		client:        newClient(bus, config, token),
 		playbackState: nil,
 		deviceId:      deviceId,
 		state:         player.READY,
@@ -64,144 +134,437 @@ func New(emitter *emitter.Emitter, token *oauth2.Token, deviceId *string) (*Play
 }
 
 func (p *Player) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	p.stopPolling()
+
+	p.currentItems = nil
+	p.loaded = nil
+	p.playbackState = nil
+	p.stale = false
+	p.state = player.READY
+}
+
+func (p *Player) Restore(items []models.Item) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(items) == 0 {
+		return false, errors.New("no items to restore")
+	}
+
+	state, err := p.client.PlayerState()
+	if err != nil {
+		return false, err
+	}
+
+	p.currentItems = append([]models.Item{}, items...)
+	if state == nil || state.Item == nil || state.Item.URI != p.current() {
+		// Something else is on the player
+		p.currentItems = nil
+		return false, nil
+	}
+
+	// Spotify is still playing what it was handed before
+	p.loaded = trackURIs(p.currentItems)
+	p.loadedAt = 0
+	p.playbackState = state
+	p.polledAt = time.Now()
+	if state.Playing {
+		p.state = player.PLAYING
+		p.startPolling()
+	} else {
+		p.state = player.PAUSED
+	}
+
+	return true, nil
 }
 
 func (p *Player) Play(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.play(items)
+}
+
+func (p *Player) play(items []models.Item) error {
 	switch p.state {
 	case player.PLAYING:
 		return errors.New("already playing")
 	case player.PAUSED:
-		return p.Resume()
+		return p.resume()
 	case player.INTERRUPTED:
 		items = p.currentItems
 		break
 	}
 
-	var uris []spotify.URI
-	for _, item := range items {
-		switch item.(type) {
-		case *models.SpotifyTrack:
-			track := item.(*models.SpotifyTrack)
-			uris = append(uris, track.URI)
-			break
-		}
-	}
+	uris := trackURIs(items)
 
-	opt := spotify.PlayOptions{
-		URIs: uris,
-		PlaybackOffset: &spotify.PlaybackOffset{
-			URI: uris[0],
-		},
+	opt := p.options()
+	opt.URIs = uris
+	opt.PlaybackOffset = &spotify.PlaybackOffset{
+		URI: uris[0],
 	}
 
-	if err := p.client.PlayOpt(&opt); err != nil {
+	if err := p.client.PlayOpt(opt); err != nil {
 		return err
 	}
-	p.currentItems = items
+	p.currentItems = append([]models.Item{}, items...)
+	p.loaded = uris
+	p.loadedAt = 0
+	p.playbackState = nil
+	p.stale = false
 	p.state = player.PLAYING
-	p.currentItems[0].Play()
-	p.emitter.Emit("player.play")
+	p.bus.Publish(events.TrackStarted{})
 
-	p.startPolling(PollInterval)
+	p.startPolling()
 	return nil
 }
 
 func (p *Player) Resume() (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.resume()
+}
+
+func (p *Player) resume() error {
 	switch p.state {
 	case player.PLAYING:
 		return errors.New("already playing")
 	case player.INTERRUPTED:
-		return p.Play(p.currentItems)
+		return p.play(p.currentItems)
 	}
 
-	// SPOTIFY RETURNS A SERVER ERROR IF THERE IS A TRACK CURRENTLY PLAYING
-	if err := p.client.Play(); err != nil {
+	if p.stale {
+		if err := p.replaceTracks(); err != nil {
+			return err
+		}
+	} else if err := p.client.PlayOpt(p.options()); err != nil {
+		// SPOTIFY RETURNS A SERVER ERROR IF THERE IS A TRACK CURRENTLY PLAYING
 		return err
 	}
 	p.state = player.PLAYING
-	p.currentItems[0].Play()
-	p.emitter.Emit("player.play")
+	p.bus.Publish(events.TrackStarted{})
 
-	p.startPolling(PollInterval)
+	p.startPolling()
 	return nil
 }
 
 func (p *Player) Pause() (error) {
-	if err := p.client.Pause(); err != nil {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.client.PauseOpt(p.options()); err != nil {
 		return err
 	}
 	p.state = player.PAUSED
-	p.currentItems[0].Pause()
-	p.emitter.Emit("player.pause")
+	p.bus.Publish(events.Paused{})
 
 	p.stopPolling()
 	return nil
 }
 
 func (p *Player) Next() (error) {
-	//if err := p.client.Next(); err != nil {
-	//	return err
-	//}
-	//
-	//p.startPolling(PollInterval)
-	//return nil
-	panic("NOT IMPLEMENTED")
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch p.state {
+	case player.INTERRUPTED:
+		return errors.New("playback interrupted")
+	}
+
+	if len(p.currentItems) == 0 {
+		return errors.New("no items to skip")
+	}
+
+	if len(p.currentItems) > 1 {
+		// Skip to the next track in spotify's context
+		if err := p.client.NextOpt(p.options()); err != nil {
+			return err
+		}
+		p.loadedAt++
+	} else if p.state == player.PLAYING {
+		// Skipped the last item of the list, stop playback until the session plays the next list
+		if err := p.client.PauseOpt(p.options()); err != nil {
+			return err
+		}
+	}
+
+	p.currentItems = p.currentItems[1:]
+
+	if len(p.currentItems) > 0 {
+		p.state = player.PLAYING
+
+		p.startPolling()
+	} else {
+		p.state = player.READY
+		p.playbackState = nil
+
+		p.stopPolling()
+	}
+
+	return nil
 }
 
 func (p *Player) Previous() (error) {
-	panic("implement me")
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch p.state {
+	case player.INTERRUPTED:
+		return errors.New("playback interrupted")
+	}
+
+	if len(p.currentItems) == 0 {
+		return errors.New("no item to replay")
+	}
+
+	return p.client.SeekOpt(0, p.options())
+}
+
+func (p *Player) Sync(items []models.Item) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(items) == 0 || len(p.currentItems) == 0 || items[0].GetID() != p.currentItems[0].GetID() {
+		return errors.New("items don't start with the current item")
+	}
+
+	p.currentItems = append([]models.Item{}, items...)
+
+	if !p.outOfSync() {
+		// Spotify carries on with the right item, later changes are picked up once they're next
+		return nil
+	}
+
+	if p.state == player.PAUSED {
+		// Replacing the tracks would start playback
+		p.stale = true
+	}
+
+	// While playing the tracks are replaced near the end of the current one, see refresh
+	return nil
+}
+
+// Whether spotify would move on to something other than the next item once the current one ends
+func (p *Player) outOfSync() bool {
+	var upcoming spotify.URI
+	if next := p.loadedAt + 1; next < len(p.loaded) {
+		upcoming = p.loaded[next]
+	}
+
+	return upcoming != p.peekNext()
+}
+
+// Whether the tracks are to be replaced before the current one ends
+func (p *Player) resyncPending() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.state == player.PLAYING && p.outOfSync()
+}
+
+// Spotify can't change the tracks of what it's playing, so play the current items from where
+// the current track is at instead
+func (p *Player) replaceTracks() error {
+	progress := p.progress()
+	if p.state == player.PLAYING && !p.polledAt.IsZero() {
+		progress += int(time.Since(p.polledAt) / time.Millisecond)
+	}
+
+	uris := trackURIs(p.currentItems)
+
+	opt := p.options()
+	opt.URIs = uris
+	opt.PlaybackOffset = &spotify.PlaybackOffset{
+		URI: uris[0],
+	}
+
+	if err := p.client.PlayOpt(opt); err != nil {
+		return err
+	}
+	p.loaded = uris
+	p.loadedAt = 0
+	p.stale = false
+
+	if progress > 0 {
+		return p.client.SeekOpt(progress, p.options())
+	}
+
+	return nil
+}
+
+// SetDevice sends playback to another of the host's devices, an empty ID leaves it to spotify
+func (p *Player) SetDevice(deviceId string) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if deviceId == "" {
+		p.deviceId = nil
+		return nil
+	}
+
+	p.deviceId = &deviceId
+
+	switch p.state {
+	case player.PLAYING, player.PAUSED:
+		// Carry on from where playback is on the new device
+		return p.client.TransferPlayback(spotify.ID(deviceId), p.state == player.PLAYING)
+	}
+
+	return nil
+}
+
+// Options for requests controlling playback, targeting the host's chosen device
+func (p *Player) options() *spotify.PlayOptions {
+	opt := &spotify.PlayOptions{}
+
+	if p.deviceId != nil {
+		id := spotify.ID(*p.deviceId)
+		opt.DeviceID = &id
+	}
+
+	return opt
+}
+
+func trackURIs(items []models.Item) []spotify.URI {
+	var uris []spotify.URI
+	for _, item := range items {
+		switch item.(type) {
+		case *models.SpotifyTrack:
+			track := item.(*models.SpotifyTrack)
+			uris = append(uris, track.URI)
+			break
+		}
+	}
+
+	return uris
+}
+
+func (p *Player) SetVolume(percent int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.client.VolumeOpt(percent, p.options())
+}
+
+func (p *Player) Seek(position int) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.currentItems) == 0 {
+		return errors.New("no item to seek")
+	}
+
+	if err := p.client.SeekOpt(position, p.options()); err != nil {
+		return err
+	}
+
+	// Report the new position until the next poll
+	if p.playbackState != nil {
+		state := *p.playbackState
+		state.Progress = position
+		p.playbackState = &state
+		p.polledAt = time.Now()
+	}
+
+	return nil
 }
 
 func (p *Player) HasItems() (bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	return len(p.currentItems) > 0
 }
 
+func (p *Player) GetItems() []models.Item {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]models.Item{}, p.currentItems...)
+}
+
 func (p *Player) stopPolling() {
-	if p.ticker != nil {
-		// Stop delivering ticks
-		p.ticker.Stop()
+	if p.stop != nil {
 		close(p.stop)
-		p.ticker = nil
+		p.stop = nil
 	}
 }
 
-func (p *Player) startPolling(interval time.Duration) {
-	if p.ticker == nil {
+func (p *Player) startPolling() {
+	if p.stop == nil {
 		p.stop = make(chan bool)
-		p.ticker = time.NewTicker(interval)
-		go p.poll()
+		go p.poll(p.stop)
 	}
 }
 
-// Update the state of the players until a session becomes inactive
-func (p *Player) poll() {
+// Update the state of the player until a session becomes inactive. Polls are scheduled from the time left
+// in the current track, and like every request wait for the scheduler shared with every other player.
+func (p *Player) poll(stop <-chan bool) {
+	delay := MinPollInterval
+	backoff := time.Duration(0)
+
 	for {
+		timer := time.NewTimer(delay)
+
 		select {
-		// Update states of our players
-		case _, ok := <-p.ticker.C:
-			if !ok {
-				return
-			}
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
 
-			state, err := p.client.PlayerState()
+		state, err := p.refresh(stop)
 
-			if err != nil {
-				log.Println("Failed getting spotify player state ", err)
-				return
-			}
+		if err != nil {
+			log.Println("Failed getting spotify player state ", err)
 
-			// TODO: Update player's state
-			if state != nil {
-				p.UpdateState(state)
+			if backoff *= 2; backoff < minBackoff {
+				backoff = minBackoff
+			} else if backoff > maxBackoff {
+				backoff = maxBackoff
 			}
 
-			break
+			delay = backoff
+			continue
+		}
 
-		case <-p.stop:
-			return
+		backoff = 0
+		delay = pollDelay(state, p.resyncPending())
+	}
+}
+
+// Get the state of the host's player and update the player's state from it, unless stop is closed meanwhile
+func (p *Player) refresh(stop <-chan bool) (*spotify.PlayerState, error) {
+	state, err := p.client.PlayerState()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Polling stops while holding the mutex, so the player can't have been stopped once it's held
+	select {
+	case <-stop:
+		return state, nil
+	default:
+	}
+
+	// Nothing on the host's device interrupts playback too
+	p.updateState(state)
+
+	if p.state == player.PLAYING && p.outOfSync() && timeLeft(state) <= resyncLead {
+		// Replacing the tracks restarts the current one, so it's only done once the next item is about to play
+		if err := p.replaceTracks(); err != nil {
+			log.Println("Failed replacing spotify's tracks", err)
 		}
 	}
+
+	return state, nil
 }
 
 func (p *Player) current() spotify.URI {
@@ -237,80 +600,48 @@ func (p *Player) peekNext() spotify.URI {
 }
 
 func (p *Player) GetState() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	return p.state
 }
 
-func (p *Player) UpdateState(newState *spotify.PlayerState) (error) {
+func (p *Player) GetProgress() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.progress()
+}
+
+// Milliseconds into the current item as of the last poll
+func (p *Player) progress() int {
 	if p.playbackState == nil {
-		if newState.Playing {
-			if newState.Item.URI == p.current() {
-				// Playback started
-				p.state = player.PLAYING
-				p.emitter.Emit("player.play", false)
-			} else {
-				p.state = player.INTERRUPTED
-				p.emitter.Emit("player.interrupted")
-			}
-		}
-	} else if p.playbackState.Playing && !newState.Playing {
-		if p.playbackState.Item.ID == newState.Item.ID {
-			if newState.Progress == 0 {
-				var prev models.Item
-				prev, p.currentItems = p.currentItems[0], p.currentItems[1:]
-				prev.Done()
-				p.emitter.Emit("player.track_finished", true)
-			} else {
-				p.state = player.PAUSED
-				p.emitter.Emit("player.paused")
-			}
-		} else {
-			if newState.Progress == 0 {
-				var prev models.Item
-				prev, p.currentItems = p.currentItems[0], p.currentItems[1:]
-				prev.Done()
-				p.emitter.Emit("player.track_finished", true)
-			} else {
-				p.state = player.INTERRUPTED
-				p.emitter.Emit("player.interrupted")
-			}
-		}
-	} else if p.playbackState.Item.ID != newState.Item.ID {
-		if !p.HasItems() {
-			// playback of something else has been started somewhere
-			p.state = player.INTERRUPTED
-			p.emitter.Emit("player.interrupted")
-		} else if p.current() == newState.Item.URI {
-			p.state = player.PLAYING
-			p.emitter.Emit("player.play", false)
-		} else if p.peekNext() == newState.Item.URI {
-			var prev models.Item
-			// Track changed to next item
-			prev, p.currentItems = p.currentItems[0], p.currentItems[1:]
-			prev.Done()
-			p.emitter.Emit("player.track_finished", false)
-		} else if len(p.currentItems) == 1 && p.playbackState.Playing && !newState.Playing {
-			var prev models.Item
-			prev, p.currentItems = p.currentItems[0], p.currentItems[1:]
-			prev.Done()
-			p.emitter.Emit("player.track_finished", true)
-		} else {
-			// playback has been started somewhere else
-			p.state = player.INTERRUPTED
-			p.emitter.Emit("player.interrupted")
-		}
-	} else {
-		// Update currently playing track
-		if !p.playbackState.Playing && newState.Playing {
-			// Playback started
-			p.state = player.PLAYING
-			p.emitter.Emit("player.play", false)
-		} else if p.playbackState.Playing && !newState.Playing {
-			p.state = player.PAUSED
-			p.emitter.Emit("player.pause")
-		}
+		return 0
 	}
 
-	p.playbackState = newState
+	return p.playbackState.Progress
+}
+
+// UpdateState moves the player along with the host's device, see state.go for the transitions
+func (p *Player) UpdateState(newState *spotify.PlayerState) (error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.updateState(newState)
 
 	return nil
 }
+
+func (p *Player) updateState(newState *spotify.PlayerState) {
+	if t := p.step(newState); t.event != nil {
+		p.bus.Publish(t.event)
+	}
+
+	// Let the session know how far into the current item playback is
+	if newState != nil && newState.Item != nil && len(p.currentItems) > 0 && newState.Item.URI == p.current() {
+		p.bus.Publish(events.Progress{Progress: newState.Progress, Duration: newState.Item.Duration})
+	}
+
+	p.playbackState = newState
+	p.polledAt = time.Now()
+}
@@ -0,0 +1,599 @@
+// Package spotifytest provides a fake of the spotify web API's player endpoints, so players can be tested
+// without a spotify account. Tests script what happens on the host's devices, like tracks ending or
+// another device taking over, and make requests fail.
+package spotifytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify"
+)
+
+// Endpoints of the fake, for counting and failing requests
+const (
+	Play     = "play"
+	Pause    = "pause"
+	Next     = "next"
+	Previous = "previous"
+	Seek     = "seek"
+	Volume   = "volume"
+	State    = "state"
+	Devices  = "devices"
+	Transfer = "transfer"
+	Token    = "token"
+)
+
+// Milliseconds a track lasts unless set by SetDuration
+const DefaultDuration = 3 * 60 * 1000
+
+// Player is the state of the host's spotify player
+type Player struct {
+	Device string
+	// The tracks being played and the one playback is on
+	URIs     []spotify.URI
+	Index    int
+	Progress int
+	Playing  bool
+	Volume   int
+}
+
+// Current is the track playback is on, empty when nothing has been played
+func (p Player) Current() spotify.URI {
+	if p.Index >= len(p.URIs) {
+		return ""
+	}
+
+	return p.URIs[p.Index]
+}
+
+type Server struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+
+	player    Player
+	devices   []spotify.PlayerDevice
+	durations map[spotify.URI]int
+
+	failures map[string][]int // Statuses the next requests to an endpoint fail with
+	requests map[string]int
+	issued   int    // Access tokens issued by refreshing
+	token    string // Access token of the last request
+}
+
+// NewServer starts a fake with a single device the host can play on
+func NewServer() *Server {
+	s := &Server{
+		player: Player{
+			Volume: 100,
+		},
+		durations: make(map[spotify.URI]int),
+		failures:  make(map[string][]int),
+		requests:  make(map[string]int),
+	}
+
+	s.AddDevice("computer", "Computer", "Computer")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/me/player", s.handle(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			s.transfer(w, r)
+		} else {
+			s.state(w, r)
+		}
+	}))
+	mux.HandleFunc("/v1/me/player/play", s.handle(s.play))
+	mux.HandleFunc("/v1/me/player/pause", s.handle(s.pause))
+	mux.HandleFunc("/v1/me/player/next", s.handle(s.next))
+	mux.HandleFunc("/v1/me/player/previous", s.handle(s.previous))
+	mux.HandleFunc("/v1/me/player/seek", s.handle(s.seek))
+	mux.HandleFunc("/v1/me/player/volume", s.handle(s.volume))
+	mux.HandleFunc("/v1/me/player/devices", s.handle(s.listDevices))
+	mux.HandleFunc("/api/token", s.handle(s.refresh))
+
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client sending the requests meant for spotify to the fake
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.server.URL)
+
+	return &http.Client{
+		Transport: redirect{
+			target: target,
+			next:   s.server.Client().Transport,
+		},
+	}
+}
+
+type redirect struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Host {
+	case "api.spotify.com", "accounts.spotify.com":
+		redirected := new(http.Request)
+		*redirected = *req
+
+		u := *req.URL
+		u.Scheme = r.target.Scheme
+		u.Host = r.target.Host
+
+		redirected.URL = &u
+		redirected.Host = ""
+		req = redirected
+	}
+
+	return r.next.RoundTrip(req)
+}
+
+// AddDevice adds a device the host can play on
+func (s *Server) AddDevice(id, name, deviceType string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.addDevice(id, name, deviceType)
+}
+
+func (s *Server) addDevice(id, name, deviceType string) {
+	for _, device := range s.devices {
+		if string(device.ID) == id {
+			return
+		}
+	}
+
+	s.devices = append(s.devices, spotify.PlayerDevice{
+		ID:     spotify.ID(id),
+		Name:   name,
+		Type:   deviceType,
+		Volume: 100,
+	})
+}
+
+// SetDuration sets how many milliseconds a track lasts
+func (s *Server) SetDuration(uri spotify.URI, duration int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.durations[uri] = duration
+}
+
+func (s *Server) duration(uri spotify.URI) int {
+	if duration, ok := s.durations[uri]; ok {
+		return duration
+	}
+
+	return DefaultDuration
+}
+
+// Advance moves playback on by ms milliseconds, carrying on through the tracks being played.
+// Playback stops at the beginning of the last track once it ends, as spotify does.
+func (s *Server) Advance(ms int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.advance(ms)
+}
+
+func (s *Server) advance(ms int) {
+	if !s.player.Playing {
+		return
+	}
+
+	s.player.Progress += ms
+
+	for s.player.Playing && s.player.Progress >= s.duration(s.player.Current()) {
+		s.player.Progress -= s.duration(s.player.Current())
+		s.skip()
+	}
+}
+
+// Move on to the next track, stopping when there's none
+func (s *Server) skip() {
+	if s.player.Index+1 < len(s.player.URIs) {
+		s.player.Index++
+	} else {
+		s.player.Playing = false
+		s.player.Progress = 0
+	}
+}
+
+// EndTrack plays the current track to its end
+func (s *Server) EndTrack() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.advance(s.duration(s.player.Current()) - s.player.Progress)
+}
+
+// PlayElsewhere starts playing uri on another device, as if the host took over their account from another app
+func (s *Server) PlayElsewhere(device string, uri spotify.URI) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.addDevice(device, device, "Smartphone")
+
+	s.player.Device = device
+	s.player.URIs = []spotify.URI{uri}
+	s.player.Index = 0
+	s.player.Progress = 0
+	s.player.Playing = true
+}
+
+// PauseElsewhere pauses playback, as if the host paused it from another app
+func (s *Server) PauseElsewhere() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.player.Playing = false
+}
+
+// Fail makes the next requests to the endpoint fail with status
+func (s *Server) Fail(endpoint string, status int, times int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 0; i < times; i++ {
+		s.failures[endpoint] = append(s.failures[endpoint], status)
+	}
+}
+
+// Player returns the state of the host's player
+func (s *Server) Player() Player {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	player := s.player
+	player.URIs = append([]spotify.URI{}, s.player.URIs...)
+
+	return player
+}
+
+// Requests counts the requests made to an endpoint, including failed ones
+func (s *Server) Requests(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[endpoint]
+}
+
+// AccessToken is the token the last request to the API was made with
+func (s *Server) AccessToken() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.token
+}
+
+// Count, authenticate and fail requests before handling them
+func (s *Server) handle(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		endpoint := endpointOf(r)
+		s.requests[endpoint]++
+
+		if failures := s.failures[endpoint]; len(failures) > 0 {
+			s.failures[endpoint] = failures[1:]
+
+			if endpoint == Token {
+				writeJSON(w, failures[0], map[string]string{
+					"error":             "invalid_grant",
+					"error_description": "Refresh token revoked",
+				})
+			} else {
+				if failures[0] == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "1")
+				}
+
+				writeError(w, failures[0], http.StatusText(failures[0]))
+			}
+			return
+		}
+
+		if endpoint != Token {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				writeError(w, http.StatusUnauthorized, "No token provided")
+				return
+			}
+
+			s.token = token
+		}
+
+		if id := r.URL.Query().Get("device_id"); id != "" {
+			if !s.hasDevice(id) {
+				writeError(w, http.StatusNotFound, "Device not found")
+				return
+			}
+
+			s.player.Device = id
+		}
+
+		handler(w, r)
+	}
+}
+
+func endpointOf(r *http.Request) string {
+	switch strings.TrimPrefix(r.URL.Path, "/v1/me/player") {
+	case "":
+		if r.Method == "PUT" {
+			return Transfer
+		}
+		return State
+	case "/play":
+		return Play
+	case "/pause":
+		return Pause
+	case "/next":
+		return Next
+	case "/previous":
+		return Previous
+	case "/seek":
+		return Seek
+	case "/volume":
+		return Volume
+	case "/devices":
+		return Devices
+	}
+
+	return Token
+}
+
+func (s *Server) hasDevice(id string) bool {
+	for _, device := range s.devices {
+		if string(device.ID) == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": spotify.Error{
+			Status:  status,
+			Message: message,
+		},
+	})
+}
+
+// Playback can only be controlled once a device has been played on
+func (s *Server) active(w http.ResponseWriter) bool {
+	if s.player.Device == "" {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	if s.player.Device == "" || len(s.player.URIs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	uri := s.player.Current()
+	id := spotify.ID(uri[strings.LastIndex(string(uri), ":")+1:])
+
+	state := spotify.PlayerState{
+		CurrentlyPlaying: spotify.CurrentlyPlaying{
+			Progress: s.player.Progress,
+			Playing:  s.player.Playing,
+			Item: &spotify.FullTrack{
+				SimpleTrack: spotify.SimpleTrack{
+					ID:       id,
+					URI:      uri,
+					Duration: s.duration(uri),
+				},
+			},
+		},
+	}
+
+	for _, device := range s.devices {
+		if string(device.ID) == s.player.Device {
+			state.Device = device
+			state.Device.Active = true
+			state.Device.Volume = s.player.Volume
+		}
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *Server) play(w http.ResponseWriter, r *http.Request) {
+	var opt spotify.PlayOptions
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			writeError(w, http.StatusBadRequest, "Malformed json")
+			return
+		}
+	}
+
+	if s.player.Device == "" {
+		// The first device is picked when the host hasn't played on any
+		s.player.Device = string(s.devices[0].ID)
+	}
+
+	if len(opt.URIs) == 0 {
+		// Resume playback
+		if len(s.player.URIs) == 0 {
+			writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+			return
+		} else if s.player.Playing {
+			writeError(w, http.StatusForbidden, "Player command failed: Restriction violated")
+			return
+		}
+
+		s.player.Playing = true
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	index := 0
+	if opt.PlaybackOffset != nil {
+		index = opt.PlaybackOffset.Position
+
+		for i, uri := range opt.URIs {
+			if opt.PlaybackOffset.URI != "" && uri == opt.PlaybackOffset.URI {
+				index = i
+			}
+		}
+	}
+
+	s.player.URIs = opt.URIs
+	s.player.Index = index
+	s.player.Progress = 0
+	s.player.Playing = true
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	if !s.active(w) {
+		return
+	}
+
+	if !s.player.Playing {
+		writeError(w, http.StatusForbidden, "Player command failed: Restriction violated")
+		return
+	}
+
+	s.player.Playing = false
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) next(w http.ResponseWriter, r *http.Request) {
+	if !s.active(w) {
+		return
+	}
+
+	s.player.Progress = 0
+	s.skip()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) previous(w http.ResponseWriter, r *http.Request) {
+	if !s.active(w) {
+		return
+	}
+
+	if s.player.Index > 0 {
+		s.player.Index--
+	}
+	s.player.Progress = 0
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) seek(w http.ResponseWriter, r *http.Request) {
+	if !s.active(w) {
+		return
+	}
+
+	position, err := strconv.Atoi(r.URL.Query().Get("position_ms"))
+	if err != nil || position < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid position_ms")
+		return
+	}
+
+	playing := s.player.Playing
+	s.player.Playing = true
+	s.player.Progress = 0
+	// Seeking past the end of the track skips to the next one
+	s.advance(position)
+	s.player.Playing = playing && s.player.Playing
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) volume(w http.ResponseWriter, r *http.Request) {
+	if !s.active(w) {
+		return
+	}
+
+	volume, err := strconv.Atoi(r.URL.Query().Get("volume_percent"))
+	if err != nil || volume < 0 || volume > 100 {
+		writeError(w, http.StatusBadRequest, "Invalid volume_percent")
+		return
+	}
+
+	s.player.Volume = volume
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	devices := make([]spotify.PlayerDevice, len(s.devices))
+	for i, device := range s.devices {
+		devices[i] = device
+		devices[i].Active = string(device.ID) == s.player.Device
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"devices": devices,
+	})
+}
+
+func (s *Server) transfer(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		DeviceIDs []string `json:"device_ids"`
+		Play      bool     `json:"play"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.DeviceIDs) != 1 {
+		writeError(w, http.StatusBadRequest, "Malformed json")
+		return
+	}
+
+	if !s.hasDevice(body.DeviceIDs[0]) {
+		writeError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	s.player.Device = body.DeviceIDs[0]
+	s.player.Playing = body.Play && len(s.player.URIs) > 0
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) refresh(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid_request",
+		})
+		return
+	}
+
+	s.issued++
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-" + strconv.Itoa(s.issued),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"scope":        "user-read-playback-state user-modify-playback-state",
+	})
+}
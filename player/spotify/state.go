@@ -0,0 +1,162 @@
+package spotify
+
+import (
+	"dubclan/api/events"
+	"dubclan/api/player"
+
+	"github.com/zmb3/spotify"
+)
+
+// The player only learns what happens on the host's device by polling it. Every poll is reduced
+// to an observation and the player's state moves according to it:
+//
+//	READY        Nothing to play, polls are ignored until the player is handed items.
+//	PLAYING      The current item plays on the host's device.
+//	             - current item playing:                       stays PLAYING
+//	             - current item paused at its beginning:       ended if it was playing on the last poll,
+//	                                                           otherwise it hasn't started yet
+//	             - current item paused:                        PAUSED, publishes Paused
+//	             - next item:                                  the current item ended
+//	             - something else paused at its beginning,
+//	               after the current item was playing:         the current item ended, spotify goes
+//	                                                           back to the start once it runs out of tracks
+//	             - something else, or nothing:                 INTERRUPTED, publishes Interrupted
+//	PAUSED       As PLAYING, except the current item playing is resumed elsewhere: PLAYING, publishes TrackStarted
+//	INTERRUPTED  Waits for the current item to play again: PLAYING, publishes TrackStarted
+//
+// Once the current item ended it's dropped and TrackFinished is published. The player is then READY
+// if it was the last item, PLAYING if spotify carries on playing the next item, or PAUSED otherwise
+// so resuming puts the player's items back on the host's device.
+
+// Where a poll found the host's device relative to the player's items
+const (
+	onCurrent = iota // On the current item
+	onNext           // On the item after the current one
+	elsewhere        // On something which isn't the current or next item
+	nowhere          // Nothing, the device is gone or spotify has nothing loaded
+)
+
+// What a poll found
+type observation struct {
+	position   int
+	playing    bool
+	atStart    bool // Playback is at the beginning of what it's on
+	wasPlaying bool // The previous poll found the current item playing
+	last       bool // The current item is the last of the player's items
+}
+
+// How the player reacts to an observation
+type transition struct {
+	state    int
+	event    events.Event // Published, nil for none
+	finished bool         // The current item ended
+}
+
+func transitionFrom(state int, o observation) transition {
+	switch state {
+	case player.PLAYING, player.PAUSED:
+		switch o.position {
+		case onCurrent:
+			if o.playing {
+				if state == player.PAUSED {
+					return transition{state: player.PLAYING, event: events.TrackStarted{}}
+				}
+
+				return transition{state: player.PLAYING}
+			}
+
+			if o.atStart {
+				if o.wasPlaying {
+					return ended(o)
+				}
+
+				// Spotify hasn't started playing it yet, or it was paused before it started
+				return transition{state: state}
+			}
+
+			if state == player.PLAYING {
+				return transition{state: player.PAUSED, event: events.Paused{}}
+			}
+
+			return transition{state: player.PAUSED}
+		case onNext:
+			return ended(o)
+		case elsewhere:
+			if !o.playing && o.atStart && o.wasPlaying {
+				return ended(o)
+			}
+		}
+
+		return transition{state: player.INTERRUPTED, event: events.Interrupted{}}
+	case player.INTERRUPTED:
+		if o.position == onCurrent && o.playing {
+			return transition{state: player.PLAYING, event: events.TrackStarted{}}
+		}
+	}
+
+	return transition{state: state}
+}
+
+// The current item ended
+func ended(o observation) transition {
+	t := transition{event: events.TrackFinished{}, finished: true}
+
+	switch {
+	case o.last:
+		t.state = player.READY
+	case o.position == onNext && o.playing:
+		t.state = player.PLAYING
+	default:
+		t.state = player.PAUSED
+	}
+
+	return t
+}
+
+func (p *Player) observe(newState *spotify.PlayerState) observation {
+	o := observation{
+		position: nowhere,
+		last:     len(p.currentItems) <= 1,
+	}
+
+	if previous := p.playbackState; previous != nil && previous.Item != nil {
+		o.wasPlaying = previous.Playing && previous.Item.URI == p.current()
+	}
+
+	if newState == nil || newState.Item == nil {
+		return o
+	}
+
+	o.playing = newState.Playing
+	o.atStart = newState.Progress == 0
+
+	switch {
+	case newState.Item.URI == p.current():
+		o.position = onCurrent
+	case newState.Item.URI == p.peekNext():
+		o.position = onNext
+	default:
+		o.position = elsewhere
+	}
+
+	return o
+}
+
+// Move the player to the state a poll found the host's device in, returns the transition made
+func (p *Player) step(newState *spotify.PlayerState) transition {
+	t := transitionFrom(p.state, p.observe(newState))
+
+	if t.finished {
+		p.currentItems = p.currentItems[1:]
+		p.loadedAt++
+
+		if t.state == player.PAUSED {
+			// Spotify isn't playing the remaining items, they're put back on resume
+			p.stale = true
+		}
+	}
+
+	p.state = t.state
+
+	return t
+}
@@ -0,0 +1,229 @@
+package spotify
+
+import (
+	"testing"
+
+	"dubclan/api/events"
+	"dubclan/api/player"
+
+	"github.com/zmb3/spotify"
+)
+
+var stateNames = map[int]string{
+	player.READY:       "READY",
+	player.PLAYING:     "PLAYING",
+	player.PAUSED:      "PAUSED",
+	player.INTERRUPTED: "INTERRUPTED",
+}
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		state int
+		o     observation
+		want  transition
+	}{
+		{"ready ignores playback", player.READY, observation{position: onCurrent, playing: true}, transition{state: player.READY}},
+		{"ready ignores other tracks", player.READY, observation{position: elsewhere, playing: true}, transition{state: player.READY}},
+		{"ready ignores nothing playing", player.READY, observation{position: nowhere}, transition{state: player.READY}},
+
+		{"playing carries on", player.PLAYING, observation{position: onCurrent, playing: true, wasPlaying: true}, transition{state: player.PLAYING}},
+		{"playing starts", player.PLAYING, observation{position: onCurrent, playing: true, atStart: true}, transition{state: player.PLAYING}},
+		{"playing not started yet", player.PLAYING, observation{position: onCurrent, atStart: true}, transition{state: player.PLAYING}},
+		{"playing paused elsewhere", player.PLAYING, observation{position: onCurrent, wasPlaying: true}, transition{state: player.PAUSED, event: events.Paused{}}},
+		{"playing last item ends", player.PLAYING, observation{position: onCurrent, atStart: true, wasPlaying: true, last: true}, transition{state: player.READY, event: events.TrackFinished{}, finished: true}},
+		{"playing item ends and stops", player.PLAYING, observation{position: onCurrent, atStart: true, wasPlaying: true}, transition{state: player.PAUSED, event: events.TrackFinished{}, finished: true}},
+		{"playing moves on", player.PLAYING, observation{position: onNext, playing: true, wasPlaying: true}, transition{state: player.PLAYING, event: events.TrackFinished{}, finished: true}},
+		{"playing moves on paused", player.PLAYING, observation{position: onNext, atStart: true, wasPlaying: true}, transition{state: player.PAUSED, event: events.TrackFinished{}, finished: true}},
+		{"playing wraps around", player.PLAYING, observation{position: elsewhere, atStart: true, wasPlaying: true, last: true}, transition{state: player.READY, event: events.TrackFinished{}, finished: true}},
+		{"playing interrupted", player.PLAYING, observation{position: elsewhere, playing: true, wasPlaying: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+		{"playing interrupted paused", player.PLAYING, observation{position: elsewhere, wasPlaying: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+		{"playing replaced before starting", player.PLAYING, observation{position: elsewhere, atStart: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+		{"playing device gone", player.PLAYING, observation{position: nowhere, wasPlaying: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+
+		{"paused stays paused", player.PAUSED, observation{position: onCurrent}, transition{state: player.PAUSED}},
+		{"paused at the beginning", player.PAUSED, observation{position: onCurrent, atStart: true}, transition{state: player.PAUSED}},
+		{"paused resumed elsewhere", player.PAUSED, observation{position: onCurrent, playing: true}, transition{state: player.PLAYING, event: events.TrackStarted{}}},
+		{"paused skipped elsewhere", player.PAUSED, observation{position: onNext, playing: true}, transition{state: player.PLAYING, event: events.TrackFinished{}, finished: true}},
+		{"paused skipped elsewhere paused", player.PAUSED, observation{position: onNext}, transition{state: player.PAUSED, event: events.TrackFinished{}, finished: true}},
+		{"paused interrupted", player.PAUSED, observation{position: elsewhere, playing: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+		{"paused replaced", player.PAUSED, observation{position: elsewhere, atStart: true}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+		{"paused device gone", player.PAUSED, observation{position: nowhere}, transition{state: player.INTERRUPTED, event: events.Interrupted{}}},
+
+		{"interrupted current plays again", player.INTERRUPTED, observation{position: onCurrent, playing: true}, transition{state: player.PLAYING, event: events.TrackStarted{}}},
+		{"interrupted current paused", player.INTERRUPTED, observation{position: onCurrent}, transition{state: player.INTERRUPTED}},
+		{"interrupted next plays", player.INTERRUPTED, observation{position: onNext, playing: true}, transition{state: player.INTERRUPTED}},
+		{"interrupted something else plays", player.INTERRUPTED, observation{position: elsewhere, playing: true}, transition{state: player.INTERRUPTED}},
+		{"interrupted nothing plays", player.INTERRUPTED, observation{position: nowhere}, transition{state: player.INTERRUPTED}},
+	}
+
+	for _, test := range tests {
+		if got := transitionFrom(test.state, test.o); got != test.want {
+			t.Errorf("%s: from %s expected %s %T finished %v, got %s %T finished %v", test.name, stateNames[test.state],
+				stateNames[test.want.state], test.want.event, test.want.finished,
+				stateNames[got.state], got.event, got.finished)
+		}
+	}
+}
+
+// Every state and observation leads to a known state, and the current item only ends when the
+// player had one
+func TestTransitionsExhaustive(t *testing.T) {
+	bools := []bool{false, true}
+
+	for state := range stateNames {
+		for position := onCurrent; position <= nowhere; position++ {
+			for _, playing := range bools {
+				for _, atStart := range bools {
+					for _, wasPlaying := range bools {
+						for _, last := range bools {
+							o := observation{position, playing, atStart, wasPlaying, last}
+							got := transitionFrom(state, o)
+
+							if _, known := stateNames[got.state]; !known {
+								t.Errorf("from %s with %+v: unknown state %d", stateNames[state], o, got.state)
+							}
+
+							if got.finished && state != player.PLAYING && state != player.PAUSED {
+								t.Errorf("from %s with %+v: an item ended without playing", stateNames[state], o)
+							}
+
+							if got.finished != (got.event == events.TrackFinished{}) {
+								t.Errorf("from %s with %+v: ended items must publish TrackFinished", stateNames[state], o)
+							}
+
+							if got.finished && last != (got.state == player.READY) {
+								t.Errorf("from %s with %+v: only the last item ending makes the player ready", stateNames[state], o)
+							}
+
+							if got.state == state && got.event != nil && !got.finished {
+								t.Errorf("from %s with %+v: published %T without changing state", stateNames[state], o, got.event)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func playingState(uri string, progress int) *spotify.PlayerState {
+	state := pausedState(uri, progress)
+	state.Playing = true
+
+	return state
+}
+
+func pausedState(uri string, progress int) *spotify.PlayerState {
+	state := &spotify.PlayerState{}
+	state.Item = &spotify.FullTrack{}
+	state.Item.URI = spotify.URI("spotify:track:" + uri)
+	state.Item.Duration = 180000
+	state.Progress = progress
+
+	return state
+}
+
+// A poll of a device playing nothing
+func nothing() *spotify.PlayerState {
+	return &spotify.PlayerState{}
+}
+
+// A poll and what the player should make of it
+type recordedPoll struct {
+	state *spotify.PlayerState
+	want  int          // The player's state
+	event events.Event // Published
+	items int          // Items left
+}
+
+// Sequences of polls as spotify reports them
+func TestUpdateStateSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		polls []recordedPoll
+	}{
+		{"plays through the items", []string{"a", "b"}, []recordedPoll{
+			{playingState("a", 0), player.PLAYING, nil, 2},
+			{playingState("a", 90000), player.PLAYING, nil, 2},
+			{playingState("b", 1200), player.PLAYING, events.TrackFinished{}, 1},
+			{playingState("b", 170000), player.PLAYING, nil, 1},
+			{pausedState("b", 0), player.READY, events.TrackFinished{}, 0},
+			{pausedState("b", 0), player.READY, nil, 0},
+		}},
+		{"goes back to the first track once it runs out", []string{"a", "b"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 2},
+			{playingState("b", 1000), player.PLAYING, events.TrackFinished{}, 1},
+			{pausedState("a", 0), player.READY, events.TrackFinished{}, 0},
+		}},
+		{"buffers before playing", []string{"a"}, []recordedPoll{
+			{pausedState("a", 0), player.PLAYING, nil, 1},
+			{playingState("a", 500), player.PLAYING, nil, 1},
+		}},
+		{"paused and resumed on the host's device", []string{"a", "b"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 2},
+			{pausedState("a", 5000), player.PAUSED, events.Paused{}, 2},
+			{pausedState("a", 5000), player.PAUSED, nil, 2},
+			{playingState("a", 6000), player.PLAYING, events.TrackStarted{}, 2},
+		}},
+		{"stops on the next track", []string{"a", "b", "c"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 3},
+			{pausedState("b", 0), player.PAUSED, events.TrackFinished{}, 2},
+		}},
+		{"interrupted and played again", []string{"a", "b"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 2},
+			{playingState("x", 1000), player.INTERRUPTED, events.Interrupted{}, 2},
+			{playingState("y", 1000), player.INTERRUPTED, nil, 2},
+			{playingState("b", 1000), player.INTERRUPTED, nil, 2},
+			{playingState("a", 1000), player.PLAYING, events.TrackStarted{}, 2},
+		}},
+		{"device goes away", []string{"a"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 1},
+			{nothing(), player.INTERRUPTED, events.Interrupted{}, 1},
+			{nil, player.INTERRUPTED, nil, 1},
+		}},
+		{"nothing plays after the last track", []string{"a"}, []recordedPoll{
+			{playingState("a", 1000), player.PLAYING, nil, 1},
+			{pausedState("a", 0), player.READY, events.TrackFinished{}, 0},
+			{playingState("x", 1000), player.READY, nil, 0},
+			{nothing(), player.READY, nil, 0},
+		}},
+	}
+
+	for _, test := range tests {
+		p := &Player{
+			currentItems: newTestTracks(test.items...),
+			state:        player.PLAYING,
+		}
+
+		for i, recorded := range test.polls {
+			got := p.step(recorded.state)
+			p.playbackState = recorded.state
+
+			if p.state != recorded.want || got.event != recorded.event || len(p.currentItems) != recorded.items {
+				t.Errorf("%s, poll %d: expected %s %T with %d items, got %s %T with %d items", test.name, i,
+					stateNames[recorded.want], recorded.event, recorded.items,
+					stateNames[p.state], got.event, len(p.currentItems))
+				break
+			}
+		}
+	}
+}
+
+// Items which stop partway through are put back on spotify when playback resumes
+func TestUpdateStateStale(t *testing.T) {
+	p := &Player{
+		currentItems: newTestTracks("a", "b", "c"),
+		state:        player.PLAYING,
+	}
+
+	for _, state := range []*spotify.PlayerState{playingState("a", 1000), pausedState("b", 0)} {
+		p.step(state)
+		p.playbackState = state
+	}
+
+	if !p.stale {
+		t.Fatal("expected the remaining items to be replaced on resume")
+	}
+}
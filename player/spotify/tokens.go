@@ -0,0 +1,39 @@
+package spotify
+
+import (
+	"sync"
+
+	"dubclan/api/events"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenSource refreshes the host's access token once it expires. The player's poller and the session
+// share it so the token is refreshed once, the refreshed token is handed to the session to be kept.
+type tokenSource struct {
+	mutex  sync.Mutex
+	config *oauth2.Config
+	token  *oauth2.Token
+	bus    *events.Bus
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	token, err := s.config.TokenSource(requestContext, s.token).Token()
+	if err != nil {
+		// The host has to sign in with spotify again
+		s.bus.Publish(events.ReauthRequired{Provider: "spotify", Reason: err.Error()})
+		return nil, err
+	}
+
+	s.token = token
+	s.bus.Publish(events.TokenRefreshed{Provider: "spotify", Token: token})
+
+	return token, nil
+}